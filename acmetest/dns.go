@@ -0,0 +1,59 @@
+package acmetest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// Gives the TXT records the Server checks to validate the DNS-01 challenges.
+type TXTResolver interface {
+	LookupTXT(fqdn string) []string
+}
+
+// In-memory dns.DNSServer, also the TXTResolver of the Server: what the provider adds is
+// what the Server sees, without any real DNS.
+type DNSServer struct {
+	Config dns.DNSServerConfig
+
+	mutex   sync.Mutex
+	records map[string][]string
+}
+
+func NewDNSServer() *DNSServer {
+	return &DNSServer{
+		Config:  dns.DNSServerConfig{Name: "acmetest", Type: "acmetest"},
+		records: make(map[string][]string),
+	}
+}
+
+func (d *DNSServer) IsAuthoritativeForDomain(domain string) bool {
+	return true
+}
+
+func (d *DNSServer) GetConfig() dns.DNSServerConfig {
+	return d.Config
+}
+
+// The provider gives the value quoted, as in a zone file, the quotes are not kept.
+func (d *DNSServer) AddTXTRecord(domain, name, value string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.records[name] = append(d.records[name], strings.Trim(value, "\""))
+	return nil
+}
+
+func (d *DNSServer) CleanTXTRecord(domain, name string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.records, name)
+	return nil
+}
+
+// Implements TXTResolver.
+func (d *DNSServer) LookupTXT(fqdn string) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.records[fqdn]...)
+}
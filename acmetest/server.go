@@ -0,0 +1,553 @@
+// Package acmetest provides an in-process ACME server, to test the whole certificate flow
+// offline: account registration, orders, DNS-01 validation, finalization and download.
+//
+// It implements only what lego uses from RFC 8555, checks the JWS signatures and nonces,
+// and issues the certificates from a throwaway CA created with the server.
+package acmetest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// Validity of the issued certificates when Server.CertificateValidity isn't set.
+	DefaultCertificateValidity = 90 * 24 * time.Hour
+
+	acmeErrorNamespace = "urn:ietf:params:acme:error:"
+	// Missing from lego's statuses, the order waits for its finalization.
+	statusReady = "ready"
+)
+
+// Server is a minimal ACME server listening on a local HTTPS port.
+type Server struct {
+	// The DNS-01 challenges are validated against the TXT records of this resolver.
+	Resolver TXTResolver
+	// Validity of the issued certificates, DefaultCertificateValidity when zero.
+	CertificateValidity time.Duration
+
+	httpServer *httptest.Server
+	ca         *certificateAuthority
+
+	mutex          sync.Mutex
+	lastID         int
+	accounts       map[string]*account
+	orders         map[string]*order
+	authorizations map[string]*authorization
+	certificates   map[string][]byte
+
+	// The nonces have their own lock, they are handed out by every response.
+	nonceMutex sync.Mutex
+	nonces     map[string]bool
+}
+
+type account struct {
+	id  string
+	key *jose.JSONWebKey
+	acme.Account
+}
+
+type order struct {
+	id        string
+	accountID string
+	acme.Order
+}
+
+type authorization struct {
+	id      string
+	orderID string
+	acme.Authorization
+}
+
+// Start a new server, to be closed with Close.
+func NewServer(resolver TXTResolver) (*Server, error) {
+	ca, err := newCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Resolver:       resolver,
+		ca:             ca,
+		nonces:         make(map[string]bool),
+		accounts:       make(map[string]*account),
+		orders:         make(map[string]*order),
+		authorizations: make(map[string]*authorization),
+		certificates:   make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", s.handleDirectory)
+	mux.HandleFunc("/new-nonce", s.handleNonce)
+	mux.HandleFunc("/new-account", s.handleNewAccount)
+	mux.HandleFunc("/account/", s.handleAccount)
+	mux.HandleFunc("/new-order", s.handleNewOrder)
+	mux.HandleFunc("/order/", s.handleOrder)
+	mux.HandleFunc("/authz/", s.handleAuthorization)
+	mux.HandleFunc("/challenge/", s.handleChallenge)
+	mux.HandleFunc("/finalize/", s.handleFinalize)
+	mux.HandleFunc("/certificate/", s.handleCertificate)
+	mux.HandleFunc("/revoke-cert", s.handleRevokeCertificate)
+	s.httpServer = httptest.NewTLSServer(mux)
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// URL of the ACME directory, to give to the client as CA directory.
+func (s *Server) DirectoryURL() string {
+	return s.httpServer.URL + "/directory"
+}
+
+// HTTP client trusting the TLS certificate of the server.
+func (s *Server) HTTPClient() *http.Client {
+	return s.httpServer.Client()
+}
+
+// Root certificate of the throwaway CA, the issued chains verify up to it.
+func (s *Server) Root() *x509.Certificate {
+	return s.ca.root
+}
+
+// Pool containing the root of the throwaway CA.
+func (s *Server) Roots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.ca.root)
+	return roots
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, acme.Directory{
+		NewNonceURL:   s.url("/new-nonce"),
+		NewAccountURL: s.url("/new-account"),
+		NewOrderURL:   s.url("/new-order"),
+		RevokeCertURL: s.url("/revoke-cert"),
+		Meta:          acme.Meta{TermsOfService: s.url("/terms")},
+	})
+}
+
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	s.addNonce(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	payload, key, _, problem := s.readJWS(w, r, true)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	var request acme.Account
+	if err := json.Unmarshal(payload, &request); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, "malformed", err.Error()))
+		return
+	}
+
+	thumbprint, _ := key.Thumbprint(crypto.SHA256)
+	for _, existing := range s.accounts {
+		existingThumbprint, _ := existing.key.Thumbprint(crypto.SHA256)
+		if string(existingThumbprint) == string(thumbprint) {
+			w.Header().Set("Location", s.url("/account/"+existing.id))
+			writeJSON(w, http.StatusOK, existing.Account)
+			return
+		}
+	}
+	if request.OnlyReturnExisting {
+		writeProblem(w, newProblem(http.StatusBadRequest, "accountDoesNotExist", "no account for this key"))
+		return
+	}
+	if !request.TermsOfServiceAgreed {
+		writeProblem(w, newProblem(http.StatusForbidden, "userActionRequired", "the terms of service must be agreed"))
+		return
+	}
+
+	created := &account{id: s.newID(), key: key}
+	created.Account = acme.Account{
+		Status:               acme.StatusValid,
+		Contact:              request.Contact,
+		TermsOfServiceAgreed: true,
+		Orders:               s.url("/account/" + created.id + "/orders"),
+	}
+	s.accounts[created.id] = created
+	w.Header().Set("Location", s.url("/account/"+created.id))
+	writeJSON(w, http.StatusCreated, created.Account)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	if r.URL.Path != "/account/"+current.id {
+		writeProblem(w, newProblem(http.StatusForbidden, "unauthorized", "not the account of this key"))
+		return
+	}
+	writeJSON(w, http.StatusOK, current.Account)
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	payload, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	var request acme.Order
+	if err := json.Unmarshal(payload, &request); err != nil || len(request.Identifiers) == 0 {
+		writeProblem(w, newProblem(http.StatusBadRequest, "malformed", "the order needs identifiers"))
+		return
+	}
+
+	created := &order{id: s.newID(), accountID: current.id}
+	expires := time.Now().Add(7 * 24 * time.Hour)
+	created.Order = acme.Order{
+		Status:      acme.StatusPending,
+		Expires:     expires.Format(time.RFC3339),
+		Identifiers: request.Identifiers,
+		Finalize:    s.url("/finalize/" + created.id),
+	}
+	for _, identifier := range request.Identifiers {
+		if identifier.Type != "dns" {
+			writeProblem(w, newProblem(http.StatusBadRequest, "unsupportedIdentifier", identifier.Type))
+			return
+		}
+		authz := &authorization{id: s.newID(), orderID: created.id}
+		authz.Authorization = acme.Authorization{
+			Status:     acme.StatusPending,
+			Expires:    expires,
+			Identifier: acme.Identifier{Type: "dns", Value: strings.TrimPrefix(identifier.Value, "*.")},
+			Wildcard:   strings.HasPrefix(identifier.Value, "*."),
+			Challenges: []acme.Challenge{{
+				Type:   "dns-01",
+				URL:    s.url("/challenge/" + s.newID() + "/" + authz.id),
+				Status: acme.StatusPending,
+				Token:  newToken(),
+			}},
+		}
+		s.authorizations[authz.id] = authz
+		created.Authorizations = append(created.Authorizations, s.url("/authz/"+authz.id))
+	}
+	s.orders[created.id] = created
+	w.Header().Set("Location", s.url("/order/"+created.id))
+	writeJSON(w, http.StatusCreated, created.Order)
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	found, ok := s.orders[strings.TrimPrefix(r.URL.Path, "/order/")]
+	if !ok || found.accountID != current.id {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no such order"))
+		return
+	}
+	writeJSON(w, http.StatusOK, found.Order)
+}
+
+func (s *Server) handleAuthorization(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	authz, ok := s.authorizations[strings.TrimPrefix(r.URL.Path, "/authz/")]
+	if !ok || s.orders[authz.orderID].accountID != current.id {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no such authorization"))
+		return
+	}
+	writeJSON(w, http.StatusOK, authz.Authorization)
+}
+
+// Validate the DNS-01 challenge right away, against the TXT records of the Resolver.
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/challenge/"), "/")
+	authz, ok := s.authorizations[parts[len(parts)-1]]
+	if !ok || s.orders[authz.orderID].accountID != current.id {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no such challenge"))
+		return
+	}
+	challenge := &authz.Challenges[0]
+	if challenge.Status == acme.StatusPending {
+		s.validate(current, authz, challenge)
+	}
+	w.Header().Add("Link", "<"+s.url("/authz/"+authz.id)+`>;rel="up"`)
+	writeJSON(w, http.StatusOK, challenge)
+}
+
+func (s *Server) validate(current *account, authz *authorization, challenge *acme.Challenge) {
+	thumbprint, _ := current.key.Thumbprint(crypto.SHA256)
+	keyAuthorization := challenge.Token + "." + base64.RawURLEncoding.EncodeToString(thumbprint)
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	expected := base64.RawURLEncoding.EncodeToString(digest[:])
+	fqdn := "_acme-challenge." + authz.Identifier.Value + "."
+
+	challenge.Status = acme.StatusInvalid
+	for _, value := range s.Resolver.LookupTXT(fqdn) {
+		if value == expected {
+			challenge.Status = acme.StatusValid
+			challenge.Validated = time.Now()
+		}
+	}
+	if challenge.Status == acme.StatusInvalid {
+		challenge.Error = newProblem(http.StatusForbidden, "unauthorized",
+			fmt.Sprintf("no TXT record matching the key authorization found at %s", fqdn))
+	}
+	authz.Status = challenge.Status
+
+	// The order is ready once all its authorizations are valid, invalid as soon as one fails.
+	validated := s.orders[authz.orderID]
+	validated.Status = statusReady
+	for _, authzURL := range validated.Authorizations {
+		status := s.authorizations[authzURL[strings.LastIndex(authzURL, "/")+1:]].Status
+		if status == acme.StatusInvalid {
+			validated.Status = acme.StatusInvalid
+			validated.Error = challenge.Error
+			return
+		}
+		if status != acme.StatusValid {
+			validated.Status = acme.StatusPending
+		}
+	}
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	payload, _, current, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	found, ok := s.orders[strings.TrimPrefix(r.URL.Path, "/finalize/")]
+	if !ok || found.accountID != current.id {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no such order"))
+		return
+	}
+	if found.Status != statusReady {
+		writeProblem(w, newProblem(http.StatusForbidden, "orderNotReady", "the order is "+found.Status))
+		return
+	}
+	var request acme.CSRMessage
+	if err := json.Unmarshal(payload, &request); err != nil {
+		writeProblem(w, newProblem(http.StatusBadRequest, "malformed", err.Error()))
+		return
+	}
+	csr, problem := parseCSR(request.Csr, found.Identifiers)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+
+	validity := s.CertificateValidity
+	if validity == 0 {
+		validity = DefaultCertificateValidity
+	}
+	chain, err := s.ca.issue(csr.PublicKey, csr.DNSNames, validity)
+	if err != nil {
+		writeProblem(w, newProblem(http.StatusInternalServerError, "serverInternal", err.Error()))
+		return
+	}
+	certificateID := s.newID()
+	s.certificates[certificateID] = chain
+	found.Status = acme.StatusValid
+	found.Certificate = s.url("/certificate/" + certificateID)
+	w.Header().Set("Location", s.url("/order/"+found.id))
+	writeJSON(w, http.StatusOK, found.Order)
+}
+
+// Check the CSR is signed and asks for the names of the order, no more no less.
+func parseCSR(encoded string, identifiers []acme.Identifier) (*x509.CertificateRequest, *acme.ProblemDetails) {
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", err.Error())
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", err.Error())
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", err.Error())
+	}
+	names := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		names[name] = true
+	}
+	if csr.Subject.CommonName != "" {
+		names[csr.Subject.CommonName] = true
+	}
+	var wanted []string
+	for _, identifier := range identifiers {
+		wanted = append(wanted, identifier.Value)
+	}
+	if len(names) != len(wanted) {
+		return nil, newProblem(http.StatusBadRequest, "badCSR", "the CSR names don't match the order")
+	}
+	for _, name := range wanted {
+		if !names[name] {
+			return nil, newProblem(http.StatusBadRequest, "badCSR", "the CSR misses "+name)
+		}
+	}
+	sort.Strings(wanted)
+	csr.DNSNames = wanted
+	return csr, nil
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, _, problem := s.readJWS(w, r, false)
+	if problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	chain, ok := s.certificates[strings.TrimPrefix(r.URL.Path, "/certificate/")]
+	if !ok {
+		writeProblem(w, newProblem(http.StatusNotFound, "malformed", "no such certificate"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(chain)
+}
+
+func (s *Server) handleRevokeCertificate(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, _, _, problem := s.readJWS(w, r, true); problem != nil {
+		writeProblem(w, problem)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Check the JWS of a POST request and return its payload. The key is either embedded (JWK),
+// only allowed when jwkAllowed, or the one of the account given by the key ID.
+// A new nonce is added to the response in any case.
+func (s *Server) readJWS(w http.ResponseWriter, r *http.Request, jwkAllowed bool) ([]byte, *jose.JSONWebKey, *account, *acme.ProblemDetails) {
+	s.addNonce(w)
+	if r.Method != http.MethodPost {
+		return nil, nil, nil, newProblem(http.StatusMethodNotAllowed, "malformed", "only POST is allowed")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", err.Error())
+	}
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil || len(jws.Signatures) != 1 {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "invalid JWS")
+	}
+	header := jws.Signatures[0].Protected
+	if !s.useNonce(header.Nonce) {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "badNonce", "unknown nonce")
+	}
+
+	var key *jose.JSONWebKey
+	var signer *account
+	if header.JSONWebKey != nil {
+		if !jwkAllowed {
+			return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "a key ID is expected")
+		}
+		key = header.JSONWebKey
+	} else {
+		signer = s.accounts[strings.TrimPrefix(header.KeyID, s.url("/account/"))]
+		if signer == nil {
+			return nil, nil, nil, newProblem(http.StatusBadRequest, "accountDoesNotExist", "unknown key ID")
+		}
+		key = signer.key
+	}
+	payload, err := jws.Verify(key)
+	if err != nil {
+		return nil, nil, nil, newProblem(http.StatusBadRequest, "malformed", "invalid JWS signature")
+	}
+	return payload, key, signer, nil
+}
+
+func (s *Server) addNonce(w http.ResponseWriter) {
+	nonce := newToken()
+	s.nonceMutex.Lock()
+	s.nonces[nonce] = true
+	s.nonceMutex.Unlock()
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+// Return whether the nonce was handed out, a nonce can only be used once.
+func (s *Server) useNonce(nonce string) bool {
+	s.nonceMutex.Lock()
+	defer s.nonceMutex.Unlock()
+	if !s.nonces[nonce] {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return true
+}
+
+func (s *Server) newID() string {
+	s.lastID++
+	return strconv.Itoa(s.lastID)
+}
+
+func newToken() string {
+	random := make([]byte, 32)
+	_, _ = rand.Read(random)
+	return base64.RawURLEncoding.EncodeToString(random)
+}
+
+func (s *Server) url(path string) string {
+	return s.httpServer.URL + path
+}
+
+func newProblem(status int, problemType, detail string) *acme.ProblemDetails {
+	return &acme.ProblemDetails{Type: acmeErrorNamespace + problemType, Detail: detail, HTTPStatus: status}
+}
+
+func writeProblem(w http.ResponseWriter, problem *acme.ProblemDetails) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.HTTPStatus)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
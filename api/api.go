@@ -0,0 +1,364 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lets_encrypt "github.com/DumesnyJeremy/lets-encrypt"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// How long the finished jobs can be polled when the config sets no retention.
+const DefaultJobRetention = 24 * time.Hour
+
+// The states of a job.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+type Config struct {
+	Tokens []TokenConfig `mapstructure:"tokens"`
+	// Issuances run at once, lets_encrypt.DefaultWorkers when zero.
+	Workers int `mapstructure:"workers"`
+	// How long the finished jobs are kept, DefaultJobRetention when zero.
+	JobRetention time.Duration `mapstructure:"job_retention"`
+}
+
+// A caller of the API, authenticated by its bearer token.
+type TokenConfig struct {
+	// Name of the caller, for the logs.
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+	// The domains the caller may request and read: a suffix allows the domain itself and all
+	// its subdomains, "example.com" allows "example.com", "www.example.com" and "*.example.com".
+	Domains []string `mapstructure:"domains"`
+}
+
+// An issuance asked through the API.
+type Job struct {
+	ID         string    `json:"id"`
+	Domain     string    `json:"domain"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+
+	caller string
+}
+
+// The HTTP API of a LetsEncrypt client, for the callers without DNS credentials:
+//
+//	POST /v1/certificates         {"domain": "www.example.com"}, starts an issuance and returns its job
+//	GET  /v1/jobs/{id}            the status of a job
+//	GET  /v1/certificates         the stored certificates the caller may read
+//	GET  /v1/certificates/{domain} the chain and private key of a certificate
+//
+// The requests carry "Authorization: Bearer <token>", each token is limited to its domains.
+type Server struct {
+	LE     *lets_encrypt.LetsEncrypt
+	Config Config
+
+	handler http.Handler
+	queue   chan *Job
+	workers sync.WaitGroup
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	closed  bool
+}
+
+// Check the tokens and start the workers running the issuances, Close stops them.
+func NewServer(LE *lets_encrypt.LetsEncrypt, config Config) (*Server, error) {
+	// The suffixes are compared in their ASCII form, in a copy not to change the config of the caller.
+	tokens := make([]TokenConfig, len(config.Tokens))
+	for i, token := range config.Tokens {
+		if token.Token == "" {
+			return nil, errors.New("The API token " + token.Name + " is empty.")
+		}
+		tokens[i] = token
+		tokens[i].Domains = make([]string, len(token.Domains))
+		for j, suffix := range token.Domains {
+			ascii, err := dns.ToASCII(strings.TrimPrefix(suffix, "*."))
+			if err != nil {
+				return nil, err
+			}
+			tokens[i].Domains[j] = ascii
+		}
+	}
+	config.Tokens = tokens
+	if config.Workers <= 0 {
+		config.Workers = lets_encrypt.DefaultWorkers
+	}
+	if config.JobRetention == 0 {
+		config.JobRetention = DefaultJobRetention
+	}
+	s := &Server{LE: LE, Config: config, queue: make(chan *Job, 1024), jobs: make(map[string]*Job)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/certificates", s.requestCertificate)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.getJob)
+	mux.HandleFunc("GET /v1/certificates", s.listCertificates)
+	mux.HandleFunc("GET /v1/certificates/{domain}", s.getCertificate)
+	s.handler = mux
+	for i := 0; i < config.Workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Stop taking new jobs and wait for the running and pending ones.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+	s.workers.Wait()
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.LE.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return s.LE.Logger.With("component", "api")
+}
+
+func (s *Server) work() {
+	defer s.workers.Done()
+	for job := range s.queue {
+		s.setStatus(job, JobRunning, nil)
+		err := s.LE.AskCertificate(job.Domain)
+		if err != nil {
+			s.setStatus(job, JobFailed, err)
+		} else {
+			s.setStatus(job, JobSucceeded, nil)
+		}
+		s.logger().Info("job finished", "job", job.ID, "caller", job.caller, "domain", job.Domain, "error", err)
+	}
+}
+
+func (s *Server) setStatus(job *Job, status string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	job.Status = status
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if status == JobSucceeded || status == JobFailed {
+		job.FinishedAt = time.Now()
+	}
+}
+
+// Return the token of the request, nil when it has none or an unknown one.
+func (s *Server) authenticate(r *http.Request) *TokenConfig {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || given == "" {
+		return nil
+	}
+	var found *TokenConfig
+	for i := range s.Config.Tokens {
+		// Compare every token in constant time, not to tell how much of one matched.
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.Config.Tokens[i].Token)) == 1 {
+			found = &s.Config.Tokens[i]
+		}
+	}
+	return found
+}
+
+// Tell whether the token may request the domain, given in its ASCII form.
+func (token *TokenConfig) allows(domain string) bool {
+	domain = strings.TrimPrefix(domain, "*.")
+	for _, suffix := range token.Domains {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// Authenticate the request and return the domain of the path or body in its ASCII form, once the
+// token is allowed to it. The error is written when ok is false.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, domain string) (token *TokenConfig, ascii string, ok bool) {
+	token = s.authenticate(r)
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or unknown token")
+		return nil, "", false
+	}
+	if domain == "" {
+		return token, "", true
+	}
+	ascii, err := dns.ToASCII(domain)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, "", false
+	}
+	if !token.allows(ascii) {
+		writeError(w, http.StatusForbidden, "the token isn't allowed to "+ascii)
+		return nil, "", false
+	}
+	return token, ascii, true
+}
+
+type certificateRequest struct {
+	Domain string `json:"domain"`
+}
+
+func (s *Server) requestCertificate(w http.ResponseWriter, r *http.Request) {
+	var request certificateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil || request.Domain == "" {
+		writeError(w, http.StatusBadRequest, "expected {\"domain\": \"...\"}")
+		return
+	}
+	token, domain, ok := s.authorize(w, r, request.Domain)
+	if !ok {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		writeError(w, http.StatusServiceUnavailable, "the server is shutting down")
+		return
+	}
+	now := time.Now()
+	for id, job := range s.jobs {
+		// The same domain asked again while its job isn't finished gets that job.
+		if job.Domain == domain && job.FinishedAt.IsZero() {
+			w.Header().Set("Location", "/v1/jobs/"+job.ID)
+			writeJSON(w, http.StatusAccepted, job)
+			return
+		}
+		if !job.FinishedAt.IsZero() && now.Sub(job.FinishedAt) > s.Config.JobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	job := &Job{ID: newJobID(), Domain: domain, Status: JobPending, CreatedAt: now, caller: token.Name}
+	select {
+	case s.queue <- job:
+	default:
+		writeError(w, http.StatusServiceUnavailable, "too many pending jobs")
+		return
+	}
+	s.jobs[job.ID] = job
+	s.logger().Info("certificate requested", "job", job.ID, "caller", token.Name, "domain", domain)
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func newJobID() string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	token, _, ok := s.authorize(w, r, "")
+	if !ok {
+		return
+	}
+	s.mutex.Lock()
+	job, found := s.jobs[r.PathValue("id")]
+	var copied Job
+	if found {
+		copied = *job
+	}
+	s.mutex.Unlock()
+	// The jobs of the domains of other callers don't exist for this one.
+	if !found || !token.allows(copied.Domain) {
+		writeError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+	writeJSON(w, http.StatusOK, copied)
+}
+
+// A stored certificate, as listed by the API.
+type CertificateInfo struct {
+	Domain        string    `json:"domain"`
+	UnicodeDomain string    `json:"unicode_domain"`
+	NotAfter      time.Time `json:"not_after"`
+	ObtainedAt    time.Time `json:"obtained_at,omitzero"`
+}
+
+func (s *Server) listCertificates(w http.ResponseWriter, r *http.Request) {
+	token, _, ok := s.authorize(w, r, "")
+	if !ok {
+		return
+	}
+	inventory, err := s.LE.Inventory()
+	if err != nil {
+		s.logger().Error("failed to list the certificates", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list the certificates")
+		return
+	}
+	certificates := []CertificateInfo{}
+	for _, stored := range inventory {
+		if token.allows(stored.Domain) {
+			certificates = append(certificates, CertificateInfo{
+				Domain:        stored.Domain,
+				UnicodeDomain: stored.UnicodeDomain,
+				NotAfter:      stored.NotAfter,
+				ObtainedAt:    stored.ObtainedAt,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, certificates)
+}
+
+// The certificate bundle, PEM encoded.
+type CertificateBundle struct {
+	Domain string `json:"domain"`
+	// The chain, the certificate first.
+	Certificate string `json:"certificate"`
+	// Empty for a key kept in a key store.
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+func (s *Server) getCertificate(w http.ResponseWriter, r *http.Request) {
+	token, domain, ok := s.authorize(w, r, r.PathValue("domain"))
+	if !ok {
+		return
+	}
+	certificatePEM, privateKeyPEM, err := s.LE.ReadCertificate(domain)
+	if storage.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, "no certificate for "+domain)
+		return
+	}
+	if err != nil {
+		s.logger().Error("failed to read the certificate", "domain", domain, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to read the certificate")
+		return
+	}
+	s.logger().Info("certificate downloaded", "caller", token.Name, "domain", domain)
+	writeJSON(w, http.StatusOK, CertificateBundle{Domain: domain, Certificate: string(certificatePEM), PrivateKey: string(privateKeyPEM)})
+}
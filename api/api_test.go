@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	lets_encrypt "github.com/DumesnyJeremy/lets-encrypt"
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// Return a client of an ACME server of the process, validating against an in-memory DNS server.
+func newTestLetsEncrypt(t *testing.T) *lets_encrypt.LetsEncrypt {
+	dnsServer := acmetest.NewDNSServer()
+	server, err := acmetest.NewServer(dnsServer)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	t.Cleanup(server.Close)
+	acmeServer := lets_encrypt.ACMEServerConfig{CADirURL: server.DirectoryURL(), HTTPClient: server.HTTPClient()}
+	user, err := lets_encrypt.InitLetsEncryptUser(lets_encrypt.LetsEncryptUserConfig{
+		Mail: "test@example.com", AccountDir: t.TempDir(), ACMEServer: acmeServer,
+	})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	LE, err := lets_encrypt.InitLetsEncryptWithACMEServer(t.TempDir(), user.GetLEUser(), acmeServer)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	LE.Validation.Roots = server.Roots()
+	skipPropagationCheck := dns01.WrapPreCheck(func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+		return true, nil
+	})
+	provider := dns.DNSProvider{DNSServer: dnsServer, PollingInterval: time.Millisecond}
+	if err := LE.SetDNSProvider(provider, skipPropagationCheck); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return &LE
+}
+
+func call(t *testing.T, handler http.Handler, method, path, token string, body interface{}, response interface{}) int {
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	request := httptest.NewRequest(method, path, &reader)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal("Error: ", err, recorder.Body.String())
+		}
+	}
+	return recorder.Code
+}
+
+func TestServer(t *testing.T) {
+	server, err := NewServer(newTestLetsEncrypt(t), Config{Tokens: []TokenConfig{
+		{Name: "web", Token: "web-token", Domains: []string{"web.example.com", "bücher.example"}},
+		{Name: "mail", Token: "mail-token", Domains: []string{"mail.example.com"}},
+	}})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer server.Close()
+
+	request := certificateRequest{Domain: "www.web.example.com"}
+	if status := call(t, server, "POST", "/v1/certificates", "", request, nil); status != http.StatusUnauthorized {
+		t.Error("Error: expected 401, got ", status)
+	}
+	if status := call(t, server, "POST", "/v1/certificates", "other-token", request, nil); status != http.StatusUnauthorized {
+		t.Error("Error: expected 401, got ", status)
+	}
+	if status := call(t, server, "POST", "/v1/certificates", "mail-token", request, nil); status != http.StatusForbidden {
+		t.Error("Error: expected 403, got ", status)
+	}
+	// A suffix matches whole labels only.
+	spoofed := certificateRequest{Domain: "evilweb.example.com"}
+	if status := call(t, server, "POST", "/v1/certificates", "web-token", spoofed, nil); status != http.StatusForbidden {
+		t.Error("Error: expected 403, got ", status)
+	}
+
+	var job Job
+	if status := call(t, server, "POST", "/v1/certificates", "web-token", request, &job); status != http.StatusAccepted {
+		t.Fatal("Error: expected 202, got ", status)
+	}
+	var idnJob Job
+	call(t, server, "POST", "/v1/certificates", "web-token", certificateRequest{Domain: "www.Bücher.example"}, &idnJob)
+	if idnJob.Domain != "www.xn--bcher-kva.example" {
+		t.Error("Error: the domain wasn't converted ", idnJob.Domain)
+	}
+	for _, id := range []string{job.ID, idnJob.ID} {
+		deadline := time.Now().Add(10 * time.Second)
+		var polled Job
+		for polled.Status != JobSucceeded && polled.Status != JobFailed && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			call(t, server, "GET", "/v1/jobs/"+id, "web-token", nil, &polled)
+		}
+		if polled.Status != JobSucceeded || polled.FinishedAt.IsZero() {
+			t.Fatal("Error: the job didn't succeed ", polled)
+		}
+	}
+	if status := call(t, server, "GET", "/v1/jobs/"+job.ID, "mail-token", nil, nil); status != http.StatusNotFound {
+		t.Error("Error: the job of another caller was shown ", status)
+	}
+
+	var bundle CertificateBundle
+	if status := call(t, server, "GET", "/v1/certificates/www.web.example.com", "web-token", nil, &bundle); status != http.StatusOK {
+		t.Fatal("Error: expected 200, got ", status)
+	}
+	if _, err := certcrypto.ParsePEMBundle([]byte(bundle.Certificate)); err != nil || !strings.Contains(bundle.PrivateKey, "PRIVATE KEY") {
+		t.Error("Error: invalid bundle ", err)
+	}
+	if status := call(t, server, "GET", "/v1/certificates/www.web.example.com", "mail-token", nil, nil); status != http.StatusForbidden {
+		t.Error("Error: expected 403, got ", status)
+	}
+	if status := call(t, server, "GET", "/v1/certificates/api.web.example.com", "web-token", nil, nil); status != http.StatusNotFound {
+		t.Error("Error: expected 404, got ", status)
+	}
+
+	var certificates []CertificateInfo
+	call(t, server, "GET", "/v1/certificates", "web-token", nil, &certificates)
+	if len(certificates) != 2 || certificates[1].UnicodeDomain != "www.bücher.example" {
+		t.Error("Error: wrong inventory ", certificates)
+	}
+	call(t, server, "GET", "/v1/certificates", "mail-token", nil, &certificates)
+	if len(certificates) != 0 {
+		t.Error("Error: the certificates of another caller were listed ", certificates)
+	}
+}
@@ -0,0 +1,84 @@
+package lets_encrypt
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of workers of AskCertificates when none is given.
+const DefaultWorkers = 10
+
+// Result of one domain of AskCertificates.
+type CertificateResult struct {
+	Domain   string
+	Err      error
+	Duration time.Duration
+}
+
+// Ask the certificates of the domains concurrently, with at most workers orders at once.
+// A domain given twice is asked twice, one after the other. The results are in the order of the domains.
+func (LE *LetsEncrypt) AskCertificates(domains []string, workers int) []CertificateResult {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	results := make([]CertificateResult, len(domains))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(domains); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				start := time.Now()
+				err := LE.AskCertificate(domains[index])
+				results[index] = CertificateResult{Domain: domains[index], Err: err, Duration: time.Since(start)}
+			}
+		}()
+	}
+	for index := range domains {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// Locks held during the issuances: the domain directories, from the order to the files written,
+// and the challenge record names, from the challenge presented to its cleanup. The apex and the wildcard
+// of a domain share the same "_acme-challenge" name.
+type issuanceLocks struct {
+	directories keyedLocks
+	records     keyedLocks
+}
+
+// Mutexes created on demand by key. Unlocking a key not held does nothing.
+type keyedLocks struct {
+	mutex sync.Mutex
+	held  map[string]chan struct{}
+}
+
+func (l *keyedLocks) Lock(key string) {
+	for {
+		l.mutex.Lock()
+		if l.held == nil {
+			l.held = make(map[string]chan struct{})
+		}
+		released, ok := l.held[key]
+		if !ok {
+			l.held[key] = make(chan struct{})
+			l.mutex.Unlock()
+			return
+		}
+		l.mutex.Unlock()
+		<-released
+	}
+}
+
+func (l *keyedLocks) Unlock(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if released, ok := l.held[key]; ok {
+		close(released)
+		delete(l.held, key)
+	}
+}
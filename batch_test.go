@@ -0,0 +1,130 @@
+package lets_encrypt
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// Fails when a record name is added again before being cleaned.
+type exclusiveDNSServer struct {
+	*acmetest.DNSServer
+
+	mutex  sync.Mutex
+	active map[string]bool
+}
+
+func (e *exclusiveDNSServer) AddTXTRecord(domain, name, value string) error {
+	e.mutex.Lock()
+	if e.active[name] {
+		e.mutex.Unlock()
+		return errors.New("Record " + name + " already in use.")
+	}
+	e.active[name] = true
+	e.mutex.Unlock()
+	// Leave time to another order to step on the record.
+	time.Sleep(10 * time.Millisecond)
+	return e.DNSServer.AddTXTRecord(domain, name, value)
+}
+
+func (e *exclusiveDNSServer) CleanTXTRecord(domain, name string) error {
+	e.mutex.Lock()
+	delete(e.active, name)
+	e.mutex.Unlock()
+	return e.DNSServer.CleanTXTRecord(domain, name)
+}
+
+func TestAskCertificates(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, &exclusiveDNSServer{DNSServer: dnsServer, active: map[string]bool{}}, dnsServer)
+
+	// The apex and the wildcard share their challenge record, a.example.com is asked twice.
+	domains := []string{"example.com", "*.example.com", "a.example.com", "b.example.com", "a.example.com"}
+	results := LE.AskCertificates(domains, 4)
+	if len(results) != len(domains) {
+		t.Fatal("Error: expected one result per domain, got ", len(results))
+	}
+	for i, result := range results {
+		if result.Domain != domains[i] {
+			t.Error("Error: result out of order ", result.Domain)
+		}
+		if result.Err != nil {
+			t.Error("Error: ", result.Domain, " ", result.Err)
+			continue
+		}
+		cert, err := readCertificateFile(filepath.Join(LE.CertificatesRootPath, result.Domain, result.Domain+".crt"))
+		if err != nil {
+			t.Error("Error: ", err)
+		} else if cert.Subject.CommonName != result.Domain {
+			t.Error("Error: wrong certificate for ", result.Domain, ": ", cert.Subject.CommonName)
+		}
+	}
+}
+
+// Fails to add the given value.
+type failingDNSServer struct {
+	*fakeDNSServer
+	value string
+}
+
+func (f *failingDNSServer) AddTXTRecord(domain, name, value string) error {
+	if value == f.value {
+		return errors.New("Failed to add the record.")
+	}
+	return f.fakeDNSServer.AddTXTRecord(domain, name, value)
+}
+
+// The challenge whose record couldn't be added keeps the record name until its own cleanup.
+func TestLockedDNSProviderFailedPresent(t *testing.T) {
+	fqdn, failed := dns01.GetRecord("example.com", "key-a")
+	_, waiting := dns01.GetRecord("example.com", "key-b")
+	server := &failingDNSServer{fakeDNSServer: &fakeDNSServer{records: map[string]string{}}, value: "\"" + failed + "\""}
+	provider := &lockedDNSProvider{DNSProvider: &dns.DNSProvider{DNSServer: server}, records: &keyedLocks{}}
+
+	if err := provider.Present("example.com", "a", "key-a"); err == nil {
+		t.Fatal("Error: the record was added")
+	}
+	presented := make(chan error)
+	go func() { presented <- provider.Present("example.com", "b", "key-b") }()
+	select {
+	case <-presented:
+		t.Fatal("Error: the record was added before the cleanup of the failed challenge")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// A challenge never presented doesn't clean the record up.
+	if err := provider.CleanUp("example.com", "c", "key-c"); err != nil {
+		t.Error("Error: ", err)
+	}
+	if err := provider.CleanUp("example.com", "a", "key-a"); err != nil {
+		t.Error("Error: ", err)
+	}
+	if err := <-presented; err != nil {
+		t.Fatal("Error: ", err)
+	}
+	// A late cleanup of the failed challenge keeps the record and the lock of the other one.
+	if err := provider.CleanUp("example.com", "a", "key-a"); err != nil {
+		t.Error("Error: ", err)
+	}
+	if server.records[fqdn] != "\""+waiting+"\"" {
+		t.Error("Error: the record of the waiting challenge was removed")
+	}
+	go func() { presented <- provider.Present("example.com", "d", "key-d") }()
+	select {
+	case <-presented:
+		t.Fatal("Error: the record name was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := provider.CleanUp("example.com", "b", "key-b"); err != nil {
+		t.Error("Error: ", err)
+	}
+	if err := <-presented; err != nil {
+		t.Error("Error: ", err)
+	}
+}
@@ -0,0 +1,354 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// The CT policies of the browsers, see CTConfig.Policy.
+const (
+	CTPolicyChrome = "chrome"
+	CTPolicyApple  = "apple"
+)
+
+// Extension of the SCTs embedded in the certificate, RFC 6962.
+var sctListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// Certificate Transparency checks of the issued certificates, their embedded SCTs are verified
+// against the logs of the list.
+type CTConfig struct {
+	// Log list in the v3 JSON format Google and Apple publish, nothing is checked without it.
+	LogListFile string `mapstructure:"log_list_file"`
+	// CTPolicyChrome or CTPolicyApple, CTPolicyChrome by default.
+	Policy string `mapstructure:"policy"`
+	// Reject the certificates failing the policy, they are only reported otherwise.
+	Enforce bool `mapstructure:"enforce"`
+}
+
+// An SCT embedded in a certificate, and whether its log signed it.
+type SCTResult struct {
+	// Base64 of the log ID, the SHA-256 of the log key.
+	LogID     string    `json:"log_id"`
+	Log       string    `json:"log,omitempty"`
+	Operator  string    `json:"operator,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Valid     bool      `json:"valid"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// The SCTs of a certificate checked against a policy, kept in its metadata.
+type CTResult struct {
+	Policy    string `json:"policy"`
+	Compliant bool   `json:"compliant"`
+	// Valid SCTs, and the number the policy requires for the lifetime of the certificate.
+	ValidSCTs int `json:"valid_scts"`
+	Required  int `json:"required"`
+	// Distinct operators of the logs of the valid SCTs.
+	Operators int         `json:"operators"`
+	SCTs      []SCTResult `json:"scts"`
+	CheckedAt time.Time   `json:"checked_at"`
+}
+
+// Returned for a certificate failing the CT policy with Enforce, nothing is written then.
+type CTError struct {
+	Domain string
+	Result *CTResult
+}
+
+func (e *CTError) Error() string {
+	return "The certificate issued for " + e.Domain + " doesn't comply with the " + e.Result.Policy + " CT policy: " +
+		strconv.Itoa(e.Result.ValidSCTs) + " valid SCTs of " + strconv.Itoa(e.Result.Required) + " required, from " +
+		strconv.Itoa(e.Result.Operators) + " log operators."
+}
+
+type ctLog struct {
+	description string
+	operator    string
+	key         crypto.PublicKey
+	// Logs not yet qualified or rejected don't count, the SCTs of a retired log count if they
+	// are older than its retirement.
+	usable  bool
+	retired time.Time
+}
+
+// The v3 log list, only what the checks need.
+type ctLogList struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description string `json:"description"`
+			Key         []byte `json:"key"`
+			State       map[string]struct {
+				Timestamp time.Time `json:"timestamp"`
+			} `json:"state"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+// Read the log list, the logs by log ID.
+func readCTLogList(path string) (map[string]ctLog, error) {
+	listBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list ctLogList
+	if err := json.Unmarshal(listBytes, &list); err != nil {
+		return nil, errors.New("Invalid CT log list " + path + ": " + err.Error())
+	}
+	logs := make(map[string]ctLog)
+	for _, operator := range list.Operators {
+		for _, listed := range operator.Logs {
+			key, err := x509.ParsePKIXPublicKey(listed.Key)
+			if err != nil {
+				return nil, errors.New("Invalid key of the CT log " + listed.Description + ": " + err.Error())
+			}
+			log := ctLog{description: listed.Description, operator: operator.Name, key: key}
+			for state, since := range listed.State {
+				switch state {
+				case "qualified", "usable", "readonly":
+					log.usable = true
+				case "retired":
+					log.usable, log.retired = true, since.Timestamp
+				}
+			}
+			logID := sha256.Sum256(listed.Key)
+			logs[string(logID[:])] = log
+		}
+	}
+	return logs, nil
+}
+
+// Number of SCTs the policy requires for a certificate valid for lifetime. Chrome and Apple ask
+// for the same today, from at least two log operators.
+func requiredSCTs(policy string, lifetime time.Duration) (int, error) {
+	switch policy {
+	case CTPolicyChrome, CTPolicyApple:
+		if lifetime <= 180*24*time.Hour {
+			return 2, nil
+		}
+		return 3, nil
+	}
+	return 0, errors.New("Unknown CT policy: " + policy)
+}
+
+// Verify the SCTs embedded in the leaf of the chain against the log list and the policy. The
+// result is nil when no log list is set.
+func (config CTConfig) check(chainPEM []byte, now time.Time) (*CTResult, error) {
+	if config.LogListFile == "" {
+		return nil, nil
+	}
+	policy := config.Policy
+	if policy == "" {
+		policy = CTPolicyChrome
+	}
+	chain, err := certcrypto.ParsePEMBundle(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) < 2 {
+		return nil, errors.New("The chain has no issuer to verify the SCTs with.")
+	}
+	leaf := chain[0]
+	result := &CTResult{Policy: policy, CheckedAt: now}
+	result.Required, err = requiredSCTs(policy, leaf.NotAfter.Sub(leaf.NotBefore))
+	if err != nil {
+		return nil, err
+	}
+	logs, err := readCTLogList(config.LogListFile)
+	if err != nil {
+		return nil, err
+	}
+	scts, err := embeddedSCTs(leaf)
+	if err != nil {
+		return nil, err
+	}
+	issuerKeyHash := sha256.Sum256(chain[1].RawSubjectPublicKeyInfo)
+	precertificate, err := removeSCTList(leaf.RawTBSCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	operators := make(map[string]bool)
+	for _, sct := range scts {
+		sctResult := SCTResult{LogID: base64.StdEncoding.EncodeToString(sct.logID), Timestamp: sct.timestamp()}
+		log, known := logs[string(sct.logID)]
+		switch {
+		case !known:
+			sctResult.Error = "unknown log"
+		case !log.usable:
+			sctResult.Error = "log not qualified"
+		case !log.retired.IsZero() && !sctResult.Timestamp.Before(log.retired):
+			sctResult.Error = "log retired before the SCT"
+		default:
+			err = sct.verify(log.key, sctSignedData(sct.timestampMillis, issuerKeyHash[:], precertificate, sct.extensions))
+			if err != nil {
+				sctResult.Error = err.Error()
+			}
+		}
+		if known {
+			sctResult.Log, sctResult.Operator = log.description, log.operator
+		}
+		if sctResult.Error == "" {
+			sctResult.Valid = true
+			result.ValidSCTs++
+			operators[log.operator] = true
+		}
+		result.SCTs = append(result.SCTs, sctResult)
+	}
+	result.Operators = len(operators)
+	result.Compliant = result.ValidSCTs >= result.Required && result.Operators >= 2
+	return result, nil
+}
+
+// An SCT of RFC 6962, section 3.2.
+type signedCertificateTimestamp struct {
+	logID           []byte
+	timestampMillis uint64
+	extensions      []byte
+	hashAlgorithm   uint8
+	signatureType   uint8
+	signature       []byte
+}
+
+func (sct signedCertificateTimestamp) timestamp() time.Time {
+	return time.Unix(0, int64(sct.timestampMillis)*int64(time.Millisecond)).UTC()
+}
+
+// Read the SCT list extension of the certificate, none is not an error.
+func embeddedSCTs(leaf *x509.Certificate) ([]signedCertificateTimestamp, error) {
+	var listBytes []byte
+	for _, extension := range leaf.Extensions {
+		if extension.Id.Equal(sctListOID) {
+			if _, err := asn1.Unmarshal(extension.Value, &listBytes); err != nil {
+				return nil, errors.New("Invalid SCT list: " + err.Error())
+			}
+		}
+	}
+	if listBytes == nil {
+		return nil, nil
+	}
+	var scts []signedCertificateTimestamp
+	input, list := cryptobyte.String(listBytes), cryptobyte.String(nil)
+	if !input.ReadUint16LengthPrefixed(&list) || !input.Empty() {
+		return nil, errors.New("Invalid SCT list.")
+	}
+	for !list.Empty() {
+		var serialized cryptobyte.String
+		var version uint8
+		var sct signedCertificateTimestamp
+		if !list.ReadUint16LengthPrefixed(&serialized) ||
+			!serialized.ReadUint8(&version) ||
+			!serialized.ReadBytes(&sct.logID, sha256.Size) ||
+			!serialized.ReadUint64(&sct.timestampMillis) ||
+			!serialized.ReadUint16LengthPrefixed((*cryptobyte.String)(&sct.extensions)) ||
+			!serialized.ReadUint8(&sct.hashAlgorithm) ||
+			!serialized.ReadUint8(&sct.signatureType) ||
+			!serialized.ReadUint16LengthPrefixed((*cryptobyte.String)(&sct.signature)) ||
+			!serialized.Empty() {
+			return nil, errors.New("Invalid SCT.")
+		}
+		// Only the first version exists, the others can't be read.
+		if version != 0 {
+			continue
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// Return the TBSCertificate without the SCT list extension, the precertificate the logs signed.
+func removeSCTList(tbs []byte) ([]byte, error) {
+	input, fields := cryptobyte.String(tbs), cryptobyte.String(nil)
+	if !input.ReadASN1(&fields, cryptobyte_asn1.SEQUENCE) {
+		return nil, errors.New("Invalid TBSCertificate.")
+	}
+	extensionsTag := cryptobyte_asn1.Tag(3).ContextSpecific().Constructed()
+	var builder cryptobyte.Builder
+	builder.AddASN1(cryptobyte_asn1.SEQUENCE, func(tbsBuilder *cryptobyte.Builder) {
+		for !fields.Empty() {
+			var field cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !fields.ReadAnyASN1Element(&field, &tag) {
+				tbsBuilder.SetError(errors.New("Invalid TBSCertificate."))
+				return
+			}
+			if tag != extensionsTag {
+				tbsBuilder.AddBytes(field)
+				continue
+			}
+			var tagged, extensions cryptobyte.String
+			if !field.ReadASN1(&tagged, extensionsTag) || !tagged.ReadASN1(&extensions, cryptobyte_asn1.SEQUENCE) {
+				tbsBuilder.SetError(errors.New("Invalid extensions."))
+				return
+			}
+			tbsBuilder.AddASN1(extensionsTag, func(tagBuilder *cryptobyte.Builder) {
+				tagBuilder.AddASN1(cryptobyte_asn1.SEQUENCE, func(extensionsBuilder *cryptobyte.Builder) {
+					for !extensions.Empty() {
+						var extension, element, content cryptobyte.String
+						var id asn1.ObjectIdentifier
+						if !extensions.ReadASN1Element(&extension, cryptobyte_asn1.SEQUENCE) {
+							extensionsBuilder.SetError(errors.New("Invalid extension."))
+							return
+						}
+						element = extension
+						if !element.ReadASN1(&content, cryptobyte_asn1.SEQUENCE) || !content.ReadASN1ObjectIdentifier(&id) {
+							extensionsBuilder.SetError(errors.New("Invalid extension."))
+							return
+						}
+						if !id.Equal(sctListOID) {
+							extensionsBuilder.AddBytes(extension)
+						}
+					}
+				})
+			})
+		}
+	})
+	return builder.Bytes()
+}
+
+// The data signed by the log for a precertificate entry, RFC 6962 section 3.2.
+func sctSignedData(timestampMillis uint64, issuerKeyHash, precertificate, extensions []byte) []byte {
+	var builder cryptobyte.Builder
+	builder.AddUint8(0) // v1
+	builder.AddUint8(0) // certificate_timestamp
+	builder.AddUint64(timestampMillis)
+	builder.AddUint16(1) // precert_entry
+	builder.AddBytes(issuerKeyHash)
+	builder.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(precertificate) })
+	builder.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(extensions) })
+	return builder.BytesOrPanic()
+}
+
+// Verify the signature of the SCT, SHA-256 with ECDSA or RSA as RFC 6962 allows.
+func (sct signedCertificateTimestamp) verify(key crypto.PublicKey, signed []byte) error {
+	const sha256Algorithm, rsaAlgorithm, ecdsaAlgorithm = 4, 1, 3
+	if sct.hashAlgorithm != sha256Algorithm {
+		return errors.New("unsupported hash algorithm")
+	}
+	digest := sha256.Sum256(signed)
+	switch publicKey := key.(type) {
+	case *ecdsa.PublicKey:
+		if sct.signatureType == ecdsaAlgorithm && ecdsa.VerifyASN1(publicKey, digest[:], sct.signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if sct.signatureType == rsaAlgorithm && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], sct.signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
@@ -0,0 +1,226 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+type testCTLog struct {
+	operator string
+	key      crypto.Signer
+	state    string
+	// Signs the SCTs instead of key when set, to forge them.
+	signer crypto.Signer
+}
+
+func (l testCTLog) id(t *testing.T) []byte {
+	der, err := x509.MarshalPKIXPublicKey(l.key.Public())
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	id := sha256.Sum256(der)
+	return id[:]
+}
+
+// Write the logs in a v3 log list.
+func writeTestCTLogList(t *testing.T, logs []testCTLog) string {
+	type listedLog struct {
+		Description string                       `json:"description"`
+		Key         []byte                       `json:"key"`
+		State       map[string]map[string]string `json:"state"`
+	}
+	type operator struct {
+		Name string      `json:"name"`
+		Logs []listedLog `json:"logs"`
+	}
+	var list struct {
+		Operators []operator `json:"operators"`
+	}
+	for _, log := range logs {
+		der, err := x509.MarshalPKIXPublicKey(log.key.Public())
+		if err != nil {
+			t.Fatal("Error: ", err)
+		}
+		state := map[string]map[string]string{log.state: {"timestamp": "2020-01-01T00:00:00Z"}}
+		list.Operators = append(list.Operators, operator{Name: log.operator, Logs: []listedLog{{log.operator + " log", der, state}}})
+	}
+	listBytes, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	path := filepath.Join(t.TempDir(), "log_list.json")
+	if err := ioutil.WriteFile(path, listBytes, 0644); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return path
+}
+
+// Issue a chain whose leaf embeds an SCT of each log, signed over the leaf without them.
+func newTestCTChain(t *testing.T, lifetime time.Duration, logs []testCTLog) []byte {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTemplate, &caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		DNSNames:     []string{"www.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour + lifetime),
+	}
+	precertificateDER, err := x509.CreateCertificate(rand.Reader, &template, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	precertificate, _ := x509.ParseCertificate(precertificateDER)
+	issuerKeyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(scts *cryptobyte.Builder) {
+		for _, log := range logs {
+			timestamp := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+			digest := sha256.Sum256(sctSignedData(timestamp, issuerKeyHash[:], precertificate.RawTBSCertificate, nil))
+			signer := log.key
+			if log.signer != nil {
+				signer = log.signer
+			}
+			signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Fatal("Error: ", err)
+			}
+			signatureType := uint8(3)
+			if _, ok := signer.(*rsa.PrivateKey); ok {
+				signatureType = 1
+			}
+			scts.AddUint16LengthPrefixed(func(sct *cryptobyte.Builder) {
+				sct.AddUint8(0)
+				sct.AddBytes(log.id(t))
+				sct.AddUint64(timestamp)
+				sct.AddUint16(0)
+				sct.AddUint8(4)
+				sct.AddUint8(signatureType)
+				sct.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(signature) })
+			})
+		}
+	})
+	listValue, err := asn1.Marshal(list.BytesOrPanic())
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	template.ExtraExtensions = []pkix.Extension{{Id: sctListOID, Value: listValue}}
+	leafDER, err := x509.CreateCertificate(rand.Reader, &template, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+}
+
+func TestCTCheck(t *testing.T) {
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pendingKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	google := testCTLog{"Google", ecdsaKey, "usable", nil}
+	cloudflare := testCTLog{"Cloudflare", rsaKey, "qualified", nil}
+	googleOther := testCTLog{"Google", otherKey, "usable", nil}
+	pending := testCTLog{"Pending", pendingKey, "pending", nil}
+	config := CTConfig{LogListFile: writeTestCTLogList(t, []testCTLog{google, cloudflare, googleOther, pending})}
+
+	unlisted, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tests := []struct {
+		name      string
+		lifetime  time.Duration
+		logs      []testCTLog
+		valid     int
+		required  int
+		compliant bool
+	}{
+		{"two operators", 90 * 24 * time.Hour, []testCTLog{google, cloudflare}, 2, 2, true},
+		{"one operator", 90 * 24 * time.Hour, []testCTLog{google, googleOther}, 2, 2, false},
+		{"long lifetime", 200 * 24 * time.Hour, []testCTLog{google, cloudflare}, 2, 3, false},
+		{"unknown and pending logs", 90 * 24 * time.Hour, []testCTLog{google, {"Unlisted", unlisted, "usable", nil}, pending}, 1, 2, false},
+		{"no SCT", 90 * 24 * time.Hour, nil, 0, 2, false},
+	}
+	for _, test := range tests {
+		result, err := config.check(newTestCTChain(t, test.lifetime, test.logs), time.Now())
+		if err != nil {
+			t.Fatal("Error: ", test.name, ": ", err)
+		}
+		if result.ValidSCTs != test.valid || result.Required != test.required || result.Compliant != test.compliant ||
+			len(result.SCTs) != len(test.logs) {
+			t.Error("Error: ", test.name, ": wrong result ", result)
+		}
+	}
+
+	// An SCT signed by another key than the one of its log.
+	forger, _ := rsa.GenerateKey(rand.Reader, 2048)
+	chain := newTestCTChain(t, 90*24*time.Hour, []testCTLog{google, {"Cloudflare", rsaKey, "qualified", forger}})
+	result, err := config.check(chain, time.Now())
+	if err != nil || result.ValidSCTs != 1 || result.SCTs[1].Valid || result.SCTs[1].Error != "invalid signature" {
+		t.Error("Error: the forged SCT was accepted ", result, err)
+	}
+
+	if _, err := (CTConfig{LogListFile: config.LogListFile, Policy: "firefox"}).check(chain, time.Now()); err == nil {
+		t.Error("Error: an unknown policy was accepted")
+	}
+}
+
+// The certificates of acmetest have no SCT: reported in the metadata, or rejected with Enforce.
+func TestAskCertificateCT(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	LE.CT.LogListFile = writeTestCTLogList(t, []testCTLog{{"Google", logKey, "usable", nil}})
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	inventory, err := LE.Inventory()
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(inventory) != 1 || inventory[0].CT == nil || inventory[0].CT.Compliant || inventory[0].CT.Policy != CTPolicyChrome {
+		t.Error("Error: the CT result wasn't kept ", inventory)
+	}
+
+	LE.CT.Enforce = true
+	err = LE.AskCertificate("api.example.com")
+	var ctError *CTError
+	if !errors.As(err, &ctError) || ctError.Domain != "api.example.com" {
+		t.Fatal("Error: expected a CT error ", err)
+	}
+	if _, err := readStoredCertificate(LE.certificateStorage(), "api.example.com"); err == nil {
+		t.Error("Error: the certificate was written")
+	}
+}
@@ -0,0 +1,114 @@
+package lets_encrypt
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock/consul"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock/etcd"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/database"
+)
+
+// How long a distributed lock is held without refresh when no TTL is set. A node that crashed
+// holding a lock delays the others at most this long.
+const DefaultLockTTL = 30 * time.Second
+
+// How often a distributed lock held by another node is tried again.
+var distributedLockPollingInterval = time.Second
+
+// Key of the lock held by the leader, see RunScheduler.
+const leaderLockKey = "leader"
+
+// Create the distributed lock of the config.
+func InitLocker(config lock.LockConfig) (lock.Locker, error) {
+	switch config.Type {
+	case lock.LockTypeEtcd:
+		return etcd.InitLocker(config)
+	case lock.LockTypeConsul:
+		return consul.InitLocker(config)
+	case lock.LockTypeDatabase:
+		return database.InitLocker(config)
+	}
+	return nil, errors.New("Unknown lock type: " + config.Type)
+}
+
+func (LE *LetsEncrypt) lockTTL() time.Duration {
+	if LE.LockTTL > 0 {
+		return LE.LockTTL
+	}
+	return DefaultLockTTL
+}
+
+// Take the distributed lock of the key, waiting at most LockTimeout for the node holding it. The
+// lock is refreshed until the returned function releases it, lost is closed if it is lost before.
+func (LE *LetsEncrypt) lockDistributed(key string) (unlock func(), lost <-chan struct{}, err error) {
+	timeout := LE.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		lease, ok, err := LE.Locker.TryLock(key, LE.lockTTL())
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			stop, lost := LE.keepLease(key, lease)
+			return func() {
+				stop()
+				if err := lease.Unlock(); err != nil {
+					loggerOrDiscard(LE.Logger).Warn("failed to release the distributed lock", "key", key, "error", err)
+				}
+			}, lost, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, errors.New("Timeout waiting for the distributed lock " + key + ".")
+		}
+		time.Sleep(distributedLockPollingInterval)
+	}
+}
+
+// Refresh the lease every third of the TTL until stop is called. lost is closed when the lock
+// was taken over, or couldn't be refreshed for a whole TTL.
+func (LE *LetsEncrypt) keepLease(key string, lease lock.Lease) (stop func(), lost <-chan struct{}) {
+	ttl := LE.lockTTL()
+	done := make(chan struct{})
+	lostLease := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		refreshed := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			err := lease.Refresh()
+			if err == nil {
+				refreshed = time.Now()
+				continue
+			}
+			if errors.Is(err, lock.ErrLockLost) || time.Since(refreshed) >= ttl {
+				loggerOrDiscard(LE.Logger).Error("distributed lock lost", "key", key, "error", err)
+				close(lostLease)
+				return
+			}
+			loggerOrDiscard(LE.Logger).Warn("failed to refresh the distributed lock", "key", key, "error", err)
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, lostLease
+}
+
+// Return lock.ErrLockLost once lost is closed, nil while the lock is held or without lock.
+func checkLease(lost <-chan struct{}) error {
+	select {
+	case <-lost:
+		return lock.ErrLockLost
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/database"
+)
+
+func newTestDatabase(t *testing.T) *database.DatabaseStorage {
+	store, err := database.NewDatabaseStorage(storage.StorageConfig{Type: storage.StorageTypeDatabase, Database: storage.DatabaseConfig{
+		Driver: database.DriverSQLite, DSN: filepath.Join(t.TempDir(), "certificates.db"),
+	}})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Three nodes renewing the same certificate at once, only one orders it.
+func TestDistributedLockRenewsOnce(t *testing.T) {
+	distributedLockPollingInterval = 10 * time.Millisecond
+	store := newTestDatabase(t)
+	dnsServer := acmetest.NewDNSServer()
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		node, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+		node.Storage, node.Locker = store, store
+		node.Certificates = []CertificateConfig{{Domain: "www.example.com"}}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = node.RenewCertificates()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error("Error: ", err)
+		}
+	}
+	var versions int
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM certificate_versions`).Scan(&versions); err != nil || versions != 1 {
+		t.Error("Error: expected a single order, got ", versions, err)
+	}
+	var locks int
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM locks`).Scan(&locks); err != nil || locks != 0 {
+		t.Error("Error: the locks weren't released ", locks, err)
+	}
+}
+
+// A bytes.Buffer safe for the logs of a scheduler.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Contains(s string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return strings.Contains(b.buffer.String(), s)
+}
+
+func TestRunSchedulerLeaderElection(t *testing.T) {
+	store := newTestDatabase(t)
+	type node struct {
+		logs    *lockedBuffer
+		cancel  context.CancelFunc
+		stopped chan struct{}
+	}
+	start := func() node {
+		n := node{logs: &lockedBuffer{}, stopped: make(chan struct{})}
+		LE := &LetsEncrypt{Locker: store, LeaderElection: true, LockTTL: 300 * time.Millisecond,
+			Logger: slog.New(slog.NewTextHandler(n.logs, nil))}
+		var ctx context.Context
+		ctx, n.cancel = context.WithCancel(context.Background())
+		go func() {
+			defer close(n.stopped)
+			LE.RunScheduler(ctx, time.Hour)
+		}()
+		return n
+	}
+	waitFor := func(n node, message string, timeout time.Duration) bool {
+		for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if n.logs.Contains(message) {
+				return true
+			}
+		}
+		return false
+	}
+
+	first := start()
+	if !waitFor(first, "elected leader", time.Second) {
+		t.Fatal("Error: the only node wasn't elected")
+	}
+	second := start()
+	defer func() {
+		second.cancel()
+		<-second.stopped
+	}()
+	if waitFor(second, "elected leader", time.Second) {
+		t.Fatal("Error: two leaders were elected")
+	}
+	first.cancel()
+	<-first.stopped
+	if !waitFor(second, "elected leader", time.Second) {
+		t.Error("Error: the other node didn't take over")
+	}
+}
+
+// A Locker whose leases are lost on their first refresh, refreshed is closed then.
+type losingLocker struct {
+	refreshed chan struct{}
+	once      sync.Once
+}
+
+func (l *losingLocker) TryLock(key string, ttl time.Duration) (lock.Lease, bool, error) {
+	return l, true, nil
+}
+
+func (l *losingLocker) Refresh() error {
+	l.once.Do(func() { close(l.refreshed) })
+	return lock.ErrLockLost
+}
+
+func (l *losingLocker) Unlock() error {
+	return nil
+}
+
+// A DNS server adding the records once the lease was lost.
+type waitingDNSServer struct {
+	dns.DNSServer
+	wait <-chan struct{}
+}
+
+func (s *waitingDNSServer) AddTXTRecord(domain, name, value string) error {
+	<-s.wait
+	time.Sleep(10 * time.Millisecond)
+	return s.DNSServer.AddTXTRecord(domain, name, value)
+}
+
+func TestAskCertificateAbortsOnLostLock(t *testing.T) {
+	locker := &losingLocker{refreshed: make(chan struct{})}
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, &waitingDNSServer{DNSServer: dnsServer, wait: locker.refreshed}, dnsServer)
+	LE.Locker, LE.LockTTL = locker, 30*time.Millisecond
+	if err := LE.AskCertificate("www.example.com"); !errors.Is(err, lock.ErrLockLost) {
+		t.Fatal("Error: expected the lock to be lost ", err)
+	}
+	if _, err := LE.certificateStorage().ReadFile("www.example.com", "www.example.com.crt"); !storage.IsNotExist(err) {
+		t.Error("Error: the certificate was written without the lock ", err)
+	}
+}
@@ -0,0 +1,102 @@
+package lets_encrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/platform/wait"
+)
+
+// The dry-run modes, they never write in CertificatesRootPath.
+const (
+	// Run a real order against the staging directory, the certificate obtained is thrown away.
+	DryRunStaging = "staging"
+	// Only present the TXT record with the DNS provider, wait for its propagation and clean it.
+	DryRunDNS = "dns"
+)
+
+// Check the whole flow for the domain without issuing a usable certificate, to validate DNS
+// credentials or configuration changes before rolling them out.
+func (LE *LetsEncrypt) dryRun(fullDomainName string) error {
+	logger := loggerOrDiscard(LE.Logger).With("domain", fullDomainName, "dry_run", LE.DryRun)
+	if LE.dnsProvider == nil {
+		return errors.New("No DNS provider set, call SetDNSProvider first.")
+	}
+	var err error
+	switch LE.DryRun {
+	case DryRunStaging:
+		err = LE.dryRunStaging(fullDomainName)
+	case DryRunDNS:
+		err = LE.dryRunDNS(fullDomainName)
+	default:
+		return errors.New("Unknown dry-run mode: " + LE.DryRun)
+	}
+	if err != nil {
+		logger.Error("dry-run failed", "problem_type", acmeProblemType(err), "error", err)
+		return err
+	}
+	logger.Info("dry-run succeeded")
+	return nil
+}
+
+func (LE *LetsEncrypt) dryRunStaging(fullDomainName string) error {
+	server := LE.DryRunACMEServer
+	if server.CADirURL == "" {
+		server.CADirURL = lego.LEDirectoryStaging
+	}
+	client, err := LE.newRegisteredClient(server)
+	if err != nil {
+		return err
+	}
+	_, err = client.Certificate.Obtain(certificate.ObtainRequest{
+		Domains: []string{fullDomainName},
+		Bundle:  true,
+	})
+	return err
+}
+
+func (LE *LetsEncrypt) dryRunDNS(fullDomainName string) error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	keyAuth := token + ".dry-run"
+	fqdn, value := dns01.GetRecord(fullDomainName, keyAuth)
+
+	if err := LE.dnsProvider.Present(fullDomainName, token, keyAuth); err != nil {
+		return err
+	}
+	check := LE.DryRunPropagationCheck
+	if check == nil {
+		check = lookupTXTPropagation
+	}
+	timeout, interval := LE.dnsProvider.Timeout()
+	err := wait.For("propagation", timeout, interval, func() (bool, error) {
+		return check(fqdn, value)
+	})
+	if cleanErr := LE.dnsProvider.CleanUp(fullDomainName, token, keyAuth); err == nil {
+		err = cleanErr
+	}
+	return err
+}
+
+// Look for the TXT record through the system resolver.
+func lookupTXTPropagation(fqdn, value string) (bool, error) {
+	records, err := net.LookupTXT(strings.TrimSuffix(fqdn, "."))
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
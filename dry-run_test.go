@@ -0,0 +1,56 @@
+package lets_encrypt
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func TestDryRunStaging(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	staging, err := acmetest.NewServer(dnsServer)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer staging.Close()
+	LE.DryRun = DryRunStaging
+	LE.DryRunACMEServer = ACMEServerConfig{CADirURL: staging.DirectoryURL(), HTTPClient: staging.HTTPClient()}
+
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if files, _ := ioutil.ReadDir(LE.CertificatesRootPath); len(files) != 0 {
+		t.Error("Error: a dry-run must not write any certificate")
+	}
+}
+
+func TestDryRunDNS(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.DryRun = DryRunDNS
+	var checked string
+	LE.DryRunPropagationCheck = func(fqdn, value string) (bool, error) {
+		for _, record := range dnsServer.LookupTXT(fqdn) {
+			if record == value {
+				checked = fqdn
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if checked != "_acme-challenge.www.example.com." {
+		t.Error("Error: the record propagation wasn't checked")
+	}
+	if len(dnsServer.LookupTXT(checked)) != 0 {
+		t.Error("Error: the dry-run record wasn't cleaned")
+	}
+	if files, _ := ioutil.ReadDir(LE.CertificatesRootPath); len(files) != 0 {
+		t.Error("Error: a dry-run must not write any certificate")
+	}
+}
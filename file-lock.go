@@ -0,0 +1,82 @@
+package lets_encrypt
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// How long to wait for a lock held by another process when no timeout is set.
+const DefaultLockTimeout = 10 * time.Minute
+
+// How often a lock held by another process is tried again.
+const lockPollingInterval = 100 * time.Millisecond
+
+// Name of the lock file of the account directory. A certificate directory is locked through
+// <domain>.lock next to it, the directory itself is only created once the certificate is obtained.
+const lockFileName = ".lock"
+
+// An advisory lock on a file, shared by the processes using the same directories.
+type fileLock struct {
+	file *os.File
+}
+
+// Take the lock on path, creating the file if needed, exclusive or shared, waiting at most timeout.
+func lockFile(path string, exclusive bool, timeout time.Duration) (*fileLock, error) {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file, exclusive)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			return &fileLock{file: file}, nil
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, errors.New("Timeout waiting for the lock on " + path + ".")
+		}
+		time.Sleep(lockPollingInterval)
+	}
+}
+
+// Lock the certificate of the domain against the other processes, when it is stored on the local
+// file system, and against the other nodes with the Locker when the lock is exclusive. The
+// returned function releases the locks, lost is closed when the Locker lost its lock before.
+func (LE *LetsEncrypt) lockCertificate(fullDomainName string, exclusive bool) (unlock func(), lost <-chan struct{}, err error) {
+	unlockFile := func() {}
+	if localStorage, ok := LE.certificateStorage().(storage.LocalStorage); ok {
+		lock, err := lockFile(localStorage.LocalPath("", fullDomainName+".lock"), exclusive, LE.LockTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlockFile = func() { lock.Unlock() }
+	}
+	if !exclusive || LE.Locker == nil {
+		return unlockFile, nil, nil
+	}
+	unlockDistributed, lost, err := LE.lockDistributed("certificates/" + fullDomainName)
+	if err != nil {
+		unlockFile()
+		return nil, nil, err
+	}
+	return func() {
+		unlockDistributed()
+		unlockFile()
+	}, lost, nil
+}
+
+// Release the lock, closing the file releases it.
+func (l *fileLock) Unlock() error {
+	return l.file.Close()
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package lets_encrypt
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+// Flock locks belong to the open file, two opens of the same file in one process stand for
+// two processes.
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockFileName)
+	lock, err := lockFile(path, true, time.Second)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := lockFile(path, false, 200*time.Millisecond); err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Error("Error: the exclusive lock was shared ", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Error("Error: ", err)
+	}
+
+	first, err := lockFile(path, false, time.Second)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer first.Unlock()
+	second, err := lockFile(path, false, time.Second)
+	if err != nil {
+		t.Fatal("Error: the shared lock wasn't shared ", err)
+	}
+	defer second.Unlock()
+	if _, err := lockFile(path, true, 200*time.Millisecond); err == nil {
+		t.Error("Error: the exclusive lock was taken over a shared one")
+	}
+}
+
+func TestAskCertificateWaitsForTheLock(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.LockTimeout = 200 * time.Millisecond
+
+	// Another process working on the certificate.
+	lock, err := lockFile(filepath.Join(LE.CertificatesRootPath, "www.example.com.lock"), true, time.Second)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := LE.AskCertificate("www.example.com"); err == nil {
+		t.Error("Error: the certificate was asked while locked")
+	}
+	if _, err := LE.NeedsRenewal("www.example.com"); err == nil {
+		t.Error("Error: the certificate was read while locked")
+	}
+	lock.Unlock()
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Error("Error: ", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package lets_encrypt
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Try to take the flock of the file without waiting, false when another process holds it.
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,381 @@
+package lets_encrypt
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"gopkg.in/square/go-jose.v2"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
+)
+
+// Where the state of certbot or of the lego CLI is brought.
+type ImportConfig struct {
+	// The account is written where InitLetsEncryptUser reads it. Only the account registered on
+	// its ACME server is imported, CADirURL by default.
+	Account LetsEncryptUserConfig
+	// Where the certificates are written, a directory at CertificatesRootPath when nil.
+	Storage              storage.Storage
+	CertificatesRootPath string
+	// Optional, the .key files are written in plaintext without it.
+	KeyEncryption KeyEncryptionConfig
+	// Replace the account and the certificates already there, they are kept by default.
+	Overwrite bool
+}
+
+type ImportResult struct {
+	// Registration URI of the imported account, empty when none was imported.
+	AccountURI string
+	// The settings of the imported certificates, to add to LetsEncrypt.Certificates.
+	Certificates []CertificateConfig
+	// What couldn't be brought over.
+	Warnings []string
+}
+
+func (config ImportConfig) certificateStorage() storage.Storage {
+	if config.Storage != nil {
+		return config.Storage
+	}
+	return file.NewFileStorage(config.CertificatesRootPath)
+}
+
+func (config ImportConfig) caDirURL() string {
+	if config.Account.ACMEServer.CADirURL != "" {
+		return config.Account.ACMEServer.CADirURL
+	}
+	return CADirURL
+}
+
+// Import the state of certbot, from its configuration directory, /etc/letsencrypt by default:
+// the account of accounts/ and the certificates of live/ with the settings of renewal/.
+func ImportCertbot(certbotDir string, config ImportConfig) (*ImportResult, error) {
+	result := &ImportResult{}
+	renewals, err := filepath.Glob(filepath.Join(certbotDir, "renewal", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(renewals)
+	// The account used by the most certificates, when there are several.
+	accountUses := make(map[string]int)
+	for _, renewalPath := range renewals {
+		renewal, err := readCertbotRenewal(renewalPath)
+		if err != nil {
+			return nil, err
+		}
+		accountUses[renewal["renewalparams.account"]]++
+		name := strings.TrimSuffix(filepath.Base(renewalPath), ".conf")
+		if err := importCertbotCertificate(certbotDir, name, renewal, config, result); err != nil {
+			return nil, err
+		}
+	}
+
+	dirURL, err := url.Parse(config.caDirURL())
+	if err != nil {
+		return nil, err
+	}
+	accountsDir := filepath.Join(certbotDir, "accounts", dirURL.Host, filepath.FromSlash(strings.Trim(dirURL.Path, "/")))
+	entries, err := ioutil.ReadDir(accountsDir)
+	if os.IsNotExist(err) {
+		result.Warnings = append(result.Warnings, "no certbot account for "+config.caDirURL())
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	accountID := ""
+	for _, entry := range entries {
+		if entry.IsDir() && (accountID == "" || accountUses[entry.Name()] > accountUses[accountID]) {
+			accountID = entry.Name()
+		}
+	}
+	if accountID == "" {
+		result.Warnings = append(result.Warnings, "no certbot account for "+config.caDirURL())
+		return result, nil
+	}
+	accountDir := filepath.Join(accountsDir, accountID)
+	keyBytes, err := ioutil.ReadFile(filepath.Join(accountDir, "private_key.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jwk jose.JSONWebKey
+	if err := jwk.UnmarshalJSON(keyBytes); err != nil {
+		return nil, err
+	}
+	registrationBytes, err := ioutil.ReadFile(filepath.Join(accountDir, "regr.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := importAccount(jwk.Key, registrationBytes, config, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Read a renewal configuration of certbot, the keys of a section are prefixed by its name.
+func readCertbotRenewal(path string) (map[string]string, error) {
+	renewalFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer renewalFile.Close()
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(renewalFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.Trim(line, "[]") + "."
+		default:
+			if i := strings.Index(line, "="); i > 0 {
+				values[section+strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return values, scanner.Err()
+}
+
+func importCertbotCertificate(certbotDir, name string, renewal map[string]string, config ImportConfig, result *ImportResult) error {
+	fullchainPath := renewal["fullchain"]
+	if fullchainPath == "" {
+		fullchainPath = filepath.Join(certbotDir, "live", name, "fullchain.pem")
+	}
+	privkeyPath := renewal["privkey"]
+	if privkeyPath == "" {
+		privkeyPath = filepath.Join(certbotDir, "live", name, "privkey.pem")
+	}
+	profile := CertificateProfile{
+		ReuseKey:       Bool(strings.EqualFold(renewal["renewalparams.reuse_key"], "True")),
+		PreferredChain: renewal["renewalparams.preferred_chain"],
+	}
+	if server := renewal["renewalparams.server"]; server != "" && server != config.caDirURL() {
+		profile.ACMEServer.CADirURL = server
+	}
+	if before := renewal["renew_before_expiry"]; before != "" {
+		var err error
+		if profile.RenewBefore, err = parseCertbotInterval(before); err != nil {
+			result.Warnings = append(result.Warnings, name+": renew_before_expiry '"+before+"' ignored")
+		}
+	}
+	if authenticator := renewal["renewalparams.authenticator"]; authenticator != "" && !strings.HasPrefix(authenticator, "dns") {
+		result.Warnings = append(result.Warnings, name+": certbot used the "+authenticator+" authenticator, the renewals use the DNS-01 challenge")
+	}
+	return importCertificate(name, fullchainPath, privkeyPath, profile, config, result)
+}
+
+// Parse the intervals of certbot, as "30 days" or "2 weeks".
+func parseCertbotInterval(interval string) (time.Duration, error) {
+	fields := strings.Fields(interval)
+	if len(fields) != 2 {
+		return 0, errors.New("Unknown interval: " + interval)
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, err
+	}
+	units := map[string]time.Duration{"hour": time.Hour, "day": 24 * time.Hour, "week": 7 * 24 * time.Hour}
+	unit, ok := units[strings.TrimSuffix(fields[1], "s")]
+	if !ok {
+		return 0, errors.New("Unknown interval unit: " + fields[1])
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// Import the state of the lego CLI, from its .lego directory: the account of the email of the
+// config, or the only one, and the certificates of certificates/.
+func ImportLego(legoDir string, config ImportConfig) (*ImportResult, error) {
+	result := &ImportResult{}
+	certificates, err := filepath.Glob(filepath.Join(legoDir, "certificates", "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(certificates)
+	for _, certificatePath := range certificates {
+		if strings.HasSuffix(certificatePath, ".issuer.crt") {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(certificatePath), ".crt")
+		keyPath := strings.TrimSuffix(certificatePath, ".crt") + ".key"
+		if err := importCertificate(name, certificatePath, keyPath, CertificateProfile{}, config, result); err != nil {
+			return nil, err
+		}
+	}
+
+	dirURL, err := url.Parse(config.caDirURL())
+	if err != nil {
+		return nil, err
+	}
+	accountsDir := filepath.Join(legoDir, "accounts", strings.ReplaceAll(dirURL.Host, ":", "_"))
+	email := config.Account.Mail
+	if email == "" {
+		entries, err := ioutil.ReadDir(accountsDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(entries) > 1 {
+			return nil, errors.New("Several lego accounts in " + accountsDir + ", set the mail of the account to import.")
+		}
+		if len(entries) == 1 {
+			email = entries[0].Name()
+		}
+	}
+	accountBytes, err := ioutil.ReadFile(filepath.Join(accountsDir, email, "account.json"))
+	if os.IsNotExist(err) || email == "" {
+		result.Warnings = append(result.Warnings, "no lego account for "+config.caDirURL())
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		Registration json.RawMessage `json:"registration"`
+	}
+	if err := json.Unmarshal(accountBytes, &account); err != nil {
+		return nil, err
+	}
+	keyBytes, err := ioutil.ReadFile(filepath.Join(accountsDir, email, "keys", email+".key"))
+	if err != nil {
+		return nil, err
+	}
+	key, err := certcrypto.ParsePEMPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := importAccount(key, account.Registration, config, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Write the account key and its registration, the same JSON for certbot, lego and us.
+func importAccount(key interface{}, registrationBytes []byte, config ImportConfig, result *ImportResult) error {
+	var accountRegistration registration.Resource
+	if err := json.Unmarshal(registrationBytes, &accountRegistration); err != nil {
+		return err
+	}
+	if accountRegistration.URI == "" {
+		return errors.New("The imported account has no registration URI.")
+	}
+	user := LetsEncryptUser{Email: config.Account.Mail, Registration: &accountRegistration, KeyEncryption: config.Account.KeyEncryption}
+	switch accountKey := key.(type) {
+	case *ecdsa.PrivateKey:
+		user.KeyPair = accountKey
+	case *rsa.PrivateKey:
+		user.RSAKeyPair = accountKey
+	default:
+		return errors.New("Unsupported account key.")
+	}
+	store, err := accountStorage(config.Account)
+	if err != nil {
+		return err
+	}
+	if _, err := store.ReadFile("", "privKey.pem"); err == nil && !config.Overwrite {
+		return errors.New("An account already exists, set Overwrite to replace it.")
+	}
+	if err := user.writeKeys(store); err != nil {
+		return err
+	}
+	if err := user.saveAccount(store); err != nil {
+		return err
+	}
+	result.AccountURI = accountRegistration.URI
+	return nil
+}
+
+// Write the certificate and its key in the storage, named after the common name of the
+// certificate, and add its settings to the result.
+func importCertificate(name, certificatePath, keyPath string, profile CertificateProfile, config ImportConfig, result *ImportResult) error {
+	bundle, err := ioutil.ReadFile(certificatePath)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	chain, err := certcrypto.ParsePEMBundle(bundle)
+	if err != nil {
+		return errors.New(name + ": " + err.Error())
+	}
+	leaf := chain[0]
+	if err := matchPrivateKey(leaf, keyPEM); err != nil {
+		result.Warnings = append(result.Warnings, name+": skipped, "+err.Error())
+		return nil
+	}
+	domain := leaf.Subject.CommonName
+	if domain == "" && len(leaf.DNSNames) > 0 {
+		domain = leaf.DNSNames[0]
+	}
+	if domain == "" {
+		result.Warnings = append(result.Warnings, name+": skipped, the certificate has no name")
+		return nil
+	}
+	if len(leaf.DNSNames) > 1 {
+		result.Warnings = append(result.Warnings, name+": also covers "+strings.Join(otherNames(leaf.DNSNames, domain), ", ")+
+			", only "+domain+" is renewed")
+	}
+
+	store := config.certificateStorage()
+	if _, err := store.ReadFile(domain, domain+".crt"); err == nil && !config.Overwrite {
+		result.Warnings = append(result.Warnings, name+": skipped, a certificate for "+domain+" already exists")
+		return nil
+	}
+	profile.KeyType = keyTypeOf(leaf.PublicKey)
+	encryptedKey, err := config.KeyEncryption.encrypt(keyPEM)
+	if err != nil {
+		return err
+	}
+	// Neither tool tells when the key was created, the certificate is the closest.
+	metadata, err := encodeMetadata(CertificateMetadata{Domain: domain, UnicodeDomain: dns.ToUnicode(domain), ObtainedAt: leaf.NotBefore, KeyCreatedAt: leaf.NotBefore})
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		domain + ".crt":  bundle,
+		domain + ".key":  encryptedKey,
+		domain + ".json": metadata,
+	}
+	if err := storage.WriteFiles(store, domain, files); err != nil {
+		return err
+	}
+	result.Certificates = append(result.Certificates, CertificateConfig{Domain: domain, CertificateProfile: profile})
+	return nil
+}
+
+func otherNames(names []string, domain string) []string {
+	var others []string
+	for _, name := range names {
+		if name != domain {
+			others = append(others, name)
+		}
+	}
+	return others
+}
+
+// The lego key type of the public key, to keep the same kind of key on renewal.
+func keyTypeOf(publicKey crypto.PublicKey) string {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return "P" + strconv.Itoa(key.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		return strconv.Itoa(key.N.BitLen())
+	}
+	return ""
+}
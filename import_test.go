@@ -0,0 +1,157 @@
+package lets_encrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal("Error: ", err)
+	}
+}
+
+func TestImportCertbot(t *testing.T) {
+	certbotDir := t.TempDir()
+	accountKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	jwk, err := jose.JSONWebKey{Key: accountKey}.MarshalJSON()
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	accountDir := filepath.Join(certbotDir, "accounts", "acme-v02.api.letsencrypt.org", "directory", "0123abcd")
+	writeTestFile(t, filepath.Join(accountDir, "private_key.json"), jwk)
+	writeTestFile(t, filepath.Join(accountDir, "regr.json"),
+		[]byte(`{"body": {"status": "valid"}, "uri": "https://acme-v02.api.letsencrypt.org/acme/acct/42"}`))
+
+	notAfter := time.Now().Add(60 * 24 * time.Hour).Truncate(time.Second)
+	resource := newTestResource(t, "www.example.com", notAfter)
+	liveDir := filepath.Join(certbotDir, "live", "www.example.com")
+	writeTestFile(t, filepath.Join(liveDir, "fullchain.pem"), resource.Certificate)
+	writeTestFile(t, filepath.Join(liveDir, "privkey.pem"), resource.PrivateKey)
+	writeTestFile(t, filepath.Join(certbotDir, "renewal", "www.example.com.conf"), []byte(strings.Join([]string{
+		"# renew_before_expiry = 30 days",
+		"version = 1.21.0",
+		"renew_before_expiry = 2 weeks",
+		"[renewalparams]",
+		"account = 0123abcd",
+		"authenticator = webroot",
+		"reuse_key = True",
+		"server = https://acme-v02.api.letsencrypt.org/directory",
+	}, "\n")))
+	// Without a renewal configuration, the files of live/ aren't imported.
+	writeTestFile(t, filepath.Join(certbotDir, "live", "old.example.com", "fullchain.pem"), resource.Certificate)
+
+	config := ImportConfig{
+		Account: LetsEncryptUserConfig{
+			Mail:       "test@example.com",
+			AccountDir: t.TempDir(),
+			ACMEServer: ACMEServerConfig{CADirURL: "https://acme-v02.api.letsencrypt.org/directory"},
+		},
+		CertificatesRootPath: t.TempDir(),
+	}
+	result, err := ImportCertbot(certbotDir, config)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if result.AccountURI != "https://acme-v02.api.letsencrypt.org/acme/acct/42" {
+		t.Error("Error: wrong account ", result.AccountURI)
+	}
+	if len(result.Certificates) != 1 {
+		t.Fatal("Error: expected one certificate ", result.Certificates)
+	}
+	imported := result.Certificates[0]
+	if imported.Domain != "www.example.com" || imported.KeyType != "P256" || !imported.reusesKey() ||
+		imported.RenewBefore != 14*24*time.Hour || imported.ACMEServer.CADirURL != "" {
+		t.Error("Error: wrong settings ", imported)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "webroot") {
+		t.Error("Error: expected a warning about the authenticator ", result.Warnings)
+	}
+
+	// The account is read without registering a new one.
+	user, err := InitLetsEncryptUser(config.Account)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if user.RSAKeyPair == nil || !user.RSAKeyPair.Equal(accountKey) || user.Registration.URI != result.AccountURI {
+		t.Error("Error: the imported account wasn't loaded")
+	}
+
+	LE := LetsEncrypt{CertificatesRootPath: config.CertificatesRootPath, Certificates: result.Certificates}
+	if renew, err := LE.NeedsRenewal("www.example.com"); err != nil || renew {
+		t.Error("Error: the imported certificate should be kept ", renew, err)
+	}
+	metadata, err := readMetadata(LE.certificateStorage(), "www.example.com")
+	if err != nil || metadata.KeyCreatedAt.IsZero() {
+		t.Error("Error: no metadata ", metadata, err)
+	}
+
+	// The account is kept on a second import.
+	if _, err := ImportCertbot(certbotDir, config); err == nil {
+		t.Error("Error: the account was replaced")
+	}
+}
+
+func TestImportLego(t *testing.T) {
+	legoDir := t.TempDir()
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	accountDir := filepath.Join(legoDir, "accounts", "localhost_14000", "test@example.com")
+	writeTestFile(t, filepath.Join(accountDir, "keys", "test@example.com.key"), pem.EncodeToMemory(certcrypto.PEMBlock(accountKey)))
+	writeTestFile(t, filepath.Join(accountDir, "account.json"),
+		[]byte(`{"email": "test@example.com", "registration": {"body": {"status": "valid"}, "uri": "https://localhost:14000/my-account/1"}}`))
+
+	resource := newTestResource(t, "*.example.com", time.Now().Add(60*24*time.Hour))
+	certificatesDir := filepath.Join(legoDir, "certificates")
+	writeTestFile(t, filepath.Join(certificatesDir, "_.example.com.crt"), resource.Certificate)
+	writeTestFile(t, filepath.Join(certificatesDir, "_.example.com.issuer.crt"), resource.Certificate)
+	writeTestFile(t, filepath.Join(certificatesDir, "_.example.com.key"), resource.PrivateKey)
+	other := newTestResource(t, "api.example.com", time.Now().Add(60*24*time.Hour))
+	writeTestFile(t, filepath.Join(certificatesDir, "api.example.com.crt"), other.Certificate)
+	writeTestFile(t, filepath.Join(certificatesDir, "api.example.com.key"), resource.PrivateKey)
+
+	config := ImportConfig{
+		Account: LetsEncryptUserConfig{
+			AccountDir: t.TempDir(),
+			ACMEServer: ACMEServerConfig{CADirURL: "https://localhost:14000/dir"},
+		},
+		CertificatesRootPath: t.TempDir(),
+	}
+	result, err := ImportLego(legoDir, config)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(result.Certificates) != 1 || result.Certificates[0].Domain != "*.example.com" {
+		t.Error("Error: expected the wildcard certificate ", result.Certificates)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "api.example.com") {
+		t.Error("Error: expected the mismatched key to be reported ", result.Warnings)
+	}
+	user, err := InitLetsEncryptUser(config.Account)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if !user.KeyPair.Equal(accountKey) || user.Registration.URI != "https://localhost:14000/my-account/1" {
+		t.Error("Error: the imported account wasn't loaded")
+	}
+}
@@ -0,0 +1,86 @@
+package lets_encrypt
+
+import (
+	"sort"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// A certificate of the storage, as listed by Inventory.
+type StoredCertificate struct {
+	// The ASCII form of the domain, the name of its directory.
+	Domain string
+	// The Unicode form of the domain, the same as Domain unless it is an IDN.
+	UnicodeDomain string
+	NotAfter      time.Time
+	// Zero for the certificates written before the metadata existed.
+	ObtainedAt time.Time
+	// The CT compliance checked when it was obtained, nil without a CT log list.
+	CT *CTResult
+}
+
+// List the certificates of the storage, sorted by domain.
+func (LE *LetsEncrypt) Inventory() ([]StoredCertificate, error) {
+	store := LE.certificateStorage()
+	dirs, err := store.ListDirs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	var certificates []StoredCertificate
+	for _, dir := range dirs {
+		cert, err := readStoredCertificate(store, dir)
+		if storage.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := readMetadata(store, dir)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, StoredCertificate{
+			Domain:        dir,
+			UnicodeDomain: dns.ToUnicode(dir),
+			NotAfter:      cert.NotAfter,
+			ObtainedAt:    metadata.ObtainedAt,
+			CT:            metadata.CT,
+		})
+	}
+	return certificates, nil
+}
+
+// Return the PEM chain of the stored certificate of the domain and its private key, decrypted.
+// The key is nil for a key kept in a key store.
+func (LE *LetsEncrypt) ReadCertificate(fullDomainName string) (certificatePEM []byte, privateKeyPEM []byte, err error) {
+	fullDomainName, err = dns.ToASCII(fullDomainName)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlock, _, err := LE.lockCertificate(fullDomainName, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+	store := LE.certificateStorage()
+	certificatePEM, err = store.ReadFile(fullDomainName, fullDomainName+".crt")
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, err := readMetadata(store, fullDomainName)
+	if err != nil || metadata.KeyReference != nil {
+		return certificatePEM, nil, err
+	}
+	storedKey, err := store.ReadFile(fullDomainName, fullDomainName+".key")
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeyPEM, err = LE.KeyEncryption.decrypt(storedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificatePEM, privateKeyPEM, nil
+}
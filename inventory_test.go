@@ -0,0 +1,38 @@
+package lets_encrypt
+
+import (
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func TestAskCertificateIDN(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.Certificates = []CertificateConfig{{Domain: "www.Bücher.example"}}
+	if err := LE.AskCertificate("www.bücher.example"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	cert, err := readStoredCertificate(LE.certificateStorage(), "www.xn--bcher-kva.example")
+	if err != nil {
+		t.Fatal("Error: the certificate isn't stored under the ASCII name ", err)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "www.xn--bcher-kva.example" {
+		t.Error("Error: wrong names ", cert.DNSNames)
+	}
+	if renew, err := LE.NeedsRenewal("www.Bücher.example"); err != nil || renew {
+		t.Error("Error: the certificate should be found by its Unicode name ", renew, err)
+	}
+	inventory, err := LE.Inventory()
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(inventory) != 1 || inventory[0].Domain != "www.xn--bcher-kva.example" ||
+		inventory[0].UnicodeDomain != "www.bücher.example" || inventory[0].ObtainedAt.IsZero() {
+		t.Error("Error: wrong inventory ", inventory)
+	}
+
+	if err := LE.AskCertificate("-invalid.example"); err == nil {
+		t.Error("Error: an invalid name was accepted")
+	}
+}
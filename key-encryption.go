@@ -0,0 +1,131 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Work factor of the passphrase encryption, lowered by the tests.
+var scryptWorkFactor = 18
+
+// Encryption of the stored private keys, the account key and the .key of the certificates, as
+// armored age files. Only one of Passphrase or the age keys can be set. The other output formats
+// are not encrypted, PKCS#12 and JKS have their own password.
+type KeyEncryptionConfig struct {
+	Passphrase string `mapstructure:"passphrase"`
+	// Public age keys ("age1...") the private keys are encrypted to, AgeIdentityFile is needed to read them back.
+	AgeRecipients []string `mapstructure:"age_recipients"`
+	// File of age identities ("AGE-SECRET-KEY-1..."), the private keys are encrypted to them and decrypted with them.
+	AgeIdentityFile string `mapstructure:"age_identity_file"`
+}
+
+func (c KeyEncryptionConfig) enabled() bool {
+	return c.Passphrase != "" || len(c.AgeRecipients) > 0 || c.AgeIdentityFile != ""
+}
+
+// Encrypt the PEM private key, returned unchanged when no encryption is set.
+func (c KeyEncryptionConfig) encrypt(privateKey []byte) ([]byte, error) {
+	if !c.enabled() {
+		return privateKey, nil
+	}
+	recipients, err := c.recipients()
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	armorWriter := armor.NewWriter(&buffer)
+	writer, err := age.Encrypt(armorWriter, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(privateKey); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decrypt a stored private key. A key written without encryption is returned unchanged, so
+// existing keys stay readable once the encryption is set.
+func (c KeyEncryptionConfig) decrypt(stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(stored), []byte(armor.Header)) {
+		return stored, nil
+	}
+	if !c.enabled() {
+		return nil, errors.New("The private key is encrypted but no key encryption is set.")
+	}
+	identities, err := c.identities()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := age.Decrypt(armor.NewReader(bytes.NewReader(bytes.TrimSpace(stored))), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func (c KeyEncryptionConfig) recipients() ([]age.Recipient, error) {
+	if c.Passphrase != "" {
+		if len(c.AgeRecipients) > 0 || c.AgeIdentityFile != "" {
+			return nil, errors.New("A passphrase can't be used along with age keys.")
+		}
+		recipient, err := age.NewScryptRecipient(c.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipient.SetWorkFactor(scryptWorkFactor)
+		return []age.Recipient{recipient}, nil
+	}
+	var recipients []age.Recipient
+	for _, publicKey := range c.AgeRecipients {
+		recipient, err := age.ParseX25519Recipient(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if c.AgeIdentityFile != "" {
+		identities, err := c.identities()
+		if err != nil {
+			return nil, err
+		}
+		for _, identity := range identities {
+			x25519, ok := identity.(*age.X25519Identity)
+			if !ok {
+				return nil, errors.New("Only X25519 identities are supported in " + c.AgeIdentityFile + ".")
+			}
+			recipients = append(recipients, x25519.Recipient())
+		}
+	}
+	return recipients, nil
+}
+
+func (c KeyEncryptionConfig) identities() ([]age.Identity, error) {
+	if c.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(c.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+	if c.AgeIdentityFile == "" {
+		return nil, errors.New("An age identity file is needed to decrypt the private key.")
+	}
+	file, err := os.Open(c.AgeIdentityFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return age.ParseIdentities(file)
+}
@@ -0,0 +1,159 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func init() {
+	// The default work factor takes a second per key.
+	scryptWorkFactor = 10
+}
+
+func writeTestIdentity(t *testing.T) (*age.X25519Identity, string) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := ioutil.WriteFile(path, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return identity, path
+}
+
+func TestKeyEncryption(t *testing.T) {
+	_, identityFile := writeTestIdentity(t)
+	other, _ := writeTestIdentity(t)
+	configs := map[string]KeyEncryptionConfig{
+		"passphrase":    {Passphrase: "correct horse"},
+		"identity file": {AgeIdentityFile: identityFile},
+		"recipients":    {AgeRecipients: []string{other.Recipient().String()}, AgeIdentityFile: identityFile},
+	}
+	for name, config := range configs {
+		encrypted, err := config.encrypt([]byte(privKey))
+		if err != nil {
+			t.Fatal("Error: ", name, " ", err)
+		}
+		if !bytes.HasPrefix(encrypted, []byte(armor.Header)) || bytes.Contains(encrypted, []byte("PRIVATE KEY-----")) {
+			t.Error("Error: ", name, " the key isn't encrypted")
+		}
+		decrypted, err := config.decrypt(encrypted)
+		if err != nil || string(decrypted) != privKey {
+			t.Error("Error: ", name, " the key wasn't decrypted ", err)
+		}
+		if _, err := (KeyEncryptionConfig{}).decrypt(encrypted); err == nil {
+			t.Error("Error: ", name, " decrypted without any key encryption")
+		}
+	}
+
+	// A key stored before the encryption was set is still read.
+	decrypted, err := configs["passphrase"].decrypt([]byte(privKey))
+	if err != nil || string(decrypted) != privKey {
+		t.Error("Error: the plaintext key wasn't kept ", err)
+	}
+	if _, err := (KeyEncryptionConfig{Passphrase: "a", AgeIdentityFile: identityFile}).encrypt([]byte(privKey)); err == nil {
+		t.Error("Error: a passphrase was used along with age keys")
+	}
+}
+
+func TestEncryptedAccountKey(t *testing.T) {
+	server, err := acmetest.NewServer(acmetest.NewDNSServer())
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer server.Close()
+	config := LetsEncryptUserConfig{
+		Mail:          "test@example.com",
+		AccountDir:    t.TempDir(),
+		ACMEServer:    ACMEServerConfig{CADirURL: server.DirectoryURL(), HTTPClient: server.HTTPClient()},
+		KeyEncryption: KeyEncryptionConfig{Passphrase: "correct horse"},
+	}
+	created, err := InitLetsEncryptUser(config)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	stored, _ := ioutil.ReadFile(filepath.Join(config.AccountDir, "privKey.pem"))
+	if !bytes.HasPrefix(stored, []byte(armor.Header)) {
+		t.Error("Error: the account key isn't encrypted")
+	}
+	loaded, err := InitLetsEncryptUser(config)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if !loaded.KeyPair.Equal(created.KeyPair) {
+		t.Error("Error: the encrypted account key wasn't read back")
+	}
+}
+
+func TestEncryptedCertificateKeyReuse(t *testing.T) {
+	_, identityFile := writeTestIdentity(t)
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.KeyEncryption = KeyEncryptionConfig{AgeIdentityFile: identityFile}
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{ReuseKey: Bool(true)}}}
+
+	store := LE.certificateStorage()
+	readKey := func() ([]byte, error) {
+		stored, err := store.ReadFile("www.example.com", "www.example.com.key")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(stored, []byte(armor.Header)) {
+			t.Fatal("Error: the certificate key isn't encrypted")
+		}
+		return LE.KeyEncryption.decrypt(stored)
+	}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	firstKey, err := readKey()
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	renewedKey, err := readKey()
+	if err != nil || string(renewedKey) != string(firstKey) {
+		t.Error("Error: the encrypted key wasn't reused ", err)
+	}
+}
+
+func TestEncryptedAccountKeyWrongPassphrase(t *testing.T) {
+	server, err := acmetest.NewServer(acmetest.NewDNSServer())
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer server.Close()
+	config := LetsEncryptUserConfig{
+		Mail:          "test@example.com",
+		AccountDir:    t.TempDir(),
+		ACMEServer:    ACMEServerConfig{CADirURL: server.DirectoryURL(), HTTPClient: server.HTTPClient()},
+		KeyEncryption: KeyEncryptionConfig{Passphrase: "right"},
+	}
+	if _, err := InitLetsEncryptUser(config); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	files := map[string][]byte{}
+	for _, name := range []string{"privKey.pem", "pubKey.pem", "registration.json"} {
+		files[name], _ = ioutil.ReadFile(filepath.Join(config.AccountDir, name))
+	}
+
+	config.KeyEncryption.Passphrase = "wrong"
+	if _, err := InitLetsEncryptUser(config); err == nil {
+		t.Error("Error: the account was loaded with a wrong passphrase")
+	}
+	for name, content := range files {
+		if current, _ := ioutil.ReadFile(filepath.Join(config.AccountDir, name)); !bytes.Equal(current, content) {
+			t.Error("Error: ", name, " was replaced")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Return the private key of the stored certificate when its settings ask to reuse it, so the
+// renewed certificate keeps the same public key. Nil is returned when a new key must be generated:
+// reuse disabled, no key stored yet, or a key older than KeyMaxAge.
+func (LE *LetsEncrypt) reusablePrivateKey(store storage.Storage, config CertificateConfig, metadata *CertificateMetadata) (crypto.PrivateKey, error) {
+	if !config.reusesKey() {
+		return nil, nil
+	}
+	storedKey, err := store.ReadFile(config.Domain, config.Domain+".key")
+	if storage.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := LE.KeyEncryption.decrypt(storedKey)
+	if err != nil {
+		return nil, err
+	}
+	localStorage, isLocal := store.(storage.LocalStorage)
+	if metadata.KeyCreatedAt.IsZero() && isLocal {
+		// Key written before the metadata existed, its file date is the best guess.
+		info, err := os.Stat(localStorage.LocalPath(config.Domain, config.Domain+".key"))
+		if err != nil {
+			return nil, err
+		}
+		metadata.KeyCreatedAt = info.ModTime()
+	}
+	if config.KeyMaxAge > 0 && time.Since(metadata.KeyCreatedAt) >= config.KeyMaxAge {
+		loggerOrDiscard(LE.Logger).Info("private key too old, a new one is generated", "domain", config.Domain,
+			"key_created_at", metadata.KeyCreatedAt, "key_max_age", config.KeyMaxAge)
+		return nil, nil
+	}
+	return certcrypto.ParsePEMPrivateKey(keyBytes)
+}
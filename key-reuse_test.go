@@ -0,0 +1,53 @@
+package lets_encrypt
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
+)
+
+func TestReusablePrivateKey(t *testing.T) {
+	root, err := ioutil.TempDir("", "certificates")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer os.RemoveAll(root)
+	nameFolder := filepath.Join(root, "example.com")
+	if err := os.Mkdir(nameFolder, os.ModePerm); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	resource := newTestResource(t, "example.com", time.Now().Add(30*24*time.Hour))
+	if err := ioutil.WriteFile(filepath.Join(nameFolder, "example.com.key"), resource.PrivateKey, 0600); err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	LE := LetsEncrypt{CertificatesRootPath: root}
+	store := file.NewFileStorage(root)
+	metadata := CertificateMetadata{Domain: "example.com"}
+	config := CertificateConfig{Domain: "example.com"}
+	if key, err := LE.reusablePrivateKey(store, config, &metadata); err != nil || key != nil {
+		t.Error("Error: the key must not be reused without ReuseKey")
+	}
+
+	config.ReuseKey, config.KeyMaxAge = Bool(true), 365*24*time.Hour
+	key, err := LE.reusablePrivateKey(store, config, &metadata)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Error("Error: the stored key wasn't reused")
+	}
+	if metadata.KeyCreatedAt.IsZero() {
+		t.Error("Error: the key creation date should default to the key file date")
+	}
+
+	metadata.KeyCreatedAt = time.Now().Add(-400 * 24 * time.Hour)
+	if key, err := LE.reusablePrivateKey(store, config, &metadata); err != nil || key != nil {
+		t.Error("Error: a key older than KeyMaxAge must be rotated")
+	}
+}
@@ -0,0 +1,66 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+)
+
+// Open the key store of the config, to close once done. The PKCS#11 key store needs cgo.
+func InitKeyStore(config keystore.KeyStoreConfig) (keystore.KeyStore, error) {
+	switch config.Type {
+	case keystore.KeyStoreTypePKCS11:
+		return initPKCS11KeyStore(config)
+	}
+	return nil, errors.New("Unknown key store type: " + config.Type)
+}
+
+// Order the certificate with a CSR signed by the key of the domain in the key store, generated
+// on the first order. The resource has no private key.
+func (LE *LetsEncrypt) obtainWithKeyStore(client *lego.Client, config CertificateConfig, request certificate.ObtainRequest) (*certificate.Resource, crypto.PublicKey, bool, error) {
+	if LE.KeyStore == nil {
+		return nil, nil, false, errors.New("No key store for " + config.Domain + ", set KeyStore.")
+	}
+	// These need the private key in a file.
+	if len(config.OutputFormats) > 0 || config.KubernetesSecret.Namespace != "" {
+		return nil, nil, false, errors.New("The output formats and the Kubernetes Secret can't be used with a key store.")
+	}
+	keyType := config.KeyType
+	if keyType == "" {
+		keyType = string(CertificateKeyType)
+	}
+	signer, created, err := LE.KeyStore.Signer(config.Domain, keyType)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: request.Domains[0]},
+		DNSNames: request.Domains,
+	}, signer)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if created {
+		loggerOrDiscard(LE.Logger).Info("key generated in the key store", "domain", config.Domain, "key_type", keyType)
+	}
+	certificates, err := client.Certificate.ObtainForCSR(certificate.ObtainForCSRRequest{
+		CSR:            csr,
+		Bundle:         request.Bundle,
+		PreferredChain: request.PreferredChain,
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return certificates, signer.Public(), created, nil
+}
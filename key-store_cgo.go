@@ -0,0 +1,12 @@
+//go:build cgo
+
+package lets_encrypt
+
+import (
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore/pkcs11"
+)
+
+func initPKCS11KeyStore(config keystore.KeyStoreConfig) (keystore.KeyStore, error) {
+	return pkcs11.InitKeyStore(config)
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package lets_encrypt
+
+import (
+	"errors"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+)
+
+// The PKCS#11 modules are C libraries, they can't be loaded without cgo.
+func initPKCS11KeyStore(config keystore.KeyStoreConfig) (keystore.KeyStore, error) {
+	return nil, errors.New("The PKCS#11 key store needs a build with cgo.")
+}
@@ -0,0 +1,158 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// A key store keeping its keys in memory, the PKCS#11 one is tested with SoftHSMv2.
+type memoryKeyStore struct {
+	keys map[string]crypto.Signer
+}
+
+func (s *memoryKeyStore) Signer(label string, keyType string) (crypto.Signer, bool, error) {
+	if key, ok := s.keys[label]; ok {
+		return key, false, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	s.keys[label] = key
+	return key, true, nil
+}
+
+func (s *memoryKeyStore) FindSigner(label string) (crypto.Signer, error) {
+	if key, ok := s.keys[label]; ok {
+		return key, nil
+	}
+	return nil, keystore.ErrKeyNotFound
+}
+
+func (s *memoryKeyStore) Reference(label string) keystore.KeyReference {
+	return keystore.KeyReference{Type: "memory", Label: label}
+}
+
+func (s *memoryKeyStore) Close() error {
+	return nil
+}
+
+func TestAskCertificateWithKeyStore(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{UseKeyStore: Bool(true)}}}
+	if err := LE.AskCertificate("www.example.com"); err == nil {
+		t.Error("Error: a certificate was asked without key store")
+	}
+
+	keyStore := &memoryKeyStore{keys: make(map[string]crypto.Signer)}
+	LE.KeyStore = keyStore
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	store := LE.certificateStorage()
+	cert, err := readStoredCertificate(store, "www.example.com")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := matchPublicKey(cert, keyStore.keys["www.example.com"].Public()); err != nil {
+		t.Error("Error: ", err)
+	}
+	if _, err := store.ReadFile("www.example.com", "www.example.com.key"); !storage.IsNotExist(err) {
+		t.Error("Error: a .key file was written ", err)
+	}
+	metadata, err := readMetadata(store, "www.example.com")
+	if err != nil || metadata.KeyReference == nil || metadata.KeyReference.Label != "www.example.com" || metadata.KeyCreatedAt.IsZero() {
+		t.Error("Error: the key reference wasn't kept ", metadata, err)
+	}
+
+	// The key of the token is used again on renewal.
+	keyCreatedAt := metadata.KeyCreatedAt
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	renewed, _ := readStoredCertificate(store, "www.example.com")
+	if renewed.Equal(cert) || !renewed.PublicKey.(*ecdsa.PublicKey).Equal(cert.PublicKey) {
+		t.Error("Error: the certificate wasn't renewed with the same key")
+	}
+	if metadata, _ := readMetadata(store, "www.example.com"); !metadata.KeyCreatedAt.Equal(keyCreatedAt) {
+		t.Error("Error: the key creation date changed")
+	}
+
+	LE.Certificates[0].OutputFormats = []OutputFormatConfig{{Type: OutputFormatPEM}}
+	if err := LE.AskCertificate("www.example.com"); err == nil {
+		t.Error("Error: an output format was written without the private key")
+	}
+	if _, err := InitKeyStore(keystore.KeyStoreConfig{Type: "tpm"}); err == nil {
+		t.Error("Error: an unknown key store type was accepted")
+	}
+}
+
+// Moving a certificate to the key store removes its .key file.
+func TestAskCertificateMovedToKeyStore(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com"}}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	keyPath := filepath.Join(LE.CertificatesRootPath, "www.example.com", "www.example.com.key")
+	if _, err := os.Stat(keyPath); err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	LE.Certificates[0].UseKeyStore = Bool(true)
+	LE.KeyStore = &memoryKeyStore{keys: make(map[string]crypto.Signer)}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Error("Error: the previous .key file was kept ", err)
+	}
+	if _, privateKeyPEM, err := LE.ReadCertificate("www.example.com"); err != nil || privateKeyPEM != nil {
+		t.Error("Error: ", err)
+	}
+}
+
+func TestAskCertificateWithKeyStoreInDatabase(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	store := newTestDatabase(t)
+	LE.Storage = store
+	LE.KeyStore = &memoryKeyStore{keys: make(map[string]crypto.Signer)}
+	LE.Certificates = []CertificateConfig{
+		{Domain: "www.example.com", CertificateProfile: CertificateProfile{UseKeyStore: Bool(true)}},
+		{Domain: "api.example.com"},
+	}
+	for _, domain := range []string{"www.example.com", "api.example.com"} {
+		if err := LE.AskCertificate(domain); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	if _, err := store.ReadFile("www.example.com", "www.example.com.key"); !storage.IsNotExist(err) {
+		t.Error("Error: a key was stored for the key store ", err)
+	}
+
+	// Moving a certificate to the key store doesn't carry its file key over.
+	LE.Certificates[1].UseKeyStore = Bool(true)
+	if err := LE.AskCertificate("api.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	_, privateKeyPEM, err := LE.ReadCertificate("api.example.com")
+	if err != nil || privateKeyPEM != nil {
+		t.Error("Error: the previous key was kept ", err)
+	}
+	var keys int
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM certificate_versions WHERE length(private_key) > 0`).Scan(&keys); err != nil || keys != 1 {
+		t.Error("Error: expected the key of the first version only, got ", keys, err)
+	}
+}
@@ -0,0 +1,117 @@
+package lets_encrypt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Annotations set on the Kubernetes Secrets.
+const (
+	AnnotationNotAfter = "lets-encrypt/not-after"
+	AnnotationDomains  = "lets-encrypt/domains"
+)
+
+// A kubernetes.io/tls Secret created or updated after each issuance, ignored without a namespace.
+type KubernetesSecretConfig struct {
+	Namespace string `mapstructure:"namespace"`
+	// Name of the Secret, the domain by default, "*." becoming "wildcard.".
+	Name string `mapstructure:"name"`
+	// Optional, the in-cluster configuration is used without a kubeconfig file.
+	Kubeconfig string `mapstructure:"kubeconfig"`
+}
+
+func (c KubernetesSecretConfig) secretName(fullDomainName string) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return strings.Replace(fullDomainName, "*.", "wildcard.", 1)
+}
+
+// Create or update the Secret of the certificate. The other annotations and labels of an existing
+// Secret are kept.
+func (LE *LetsEncrypt) writeKubernetesSecret(certificates *certificate.Resource, fullDomainName string, config KubernetesSecretConfig) error {
+	if config.Namespace == "" {
+		return nil
+	}
+	cert, err := certcrypto.ParsePEMCertificate(certificates.Certificate)
+	if err != nil {
+		return err
+	}
+	client, err := LE.kubernetesClient(config)
+	if err != nil {
+		return err
+	}
+	secrets := client.CoreV1().Secrets(config.Namespace)
+	name := config.secretName(fullDomainName)
+	ctx := context.Background()
+
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: config.Namespace},
+			Type:       corev1.SecretTypeTLS,
+		}
+	} else if err != nil {
+		return err
+	}
+	if secret.Type != corev1.SecretTypeTLS {
+		return errors.New("The Secret " + config.Namespace + "/" + name + " exists with the type " + string(secret.Type) + ".")
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[AnnotationNotAfter] = cert.NotAfter.UTC().Format(time.RFC3339)
+	secret.Annotations[AnnotationDomains] = strings.Join(cert.DNSNames, ",")
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	secret.Labels["app.kubernetes.io/managed-by"] = "lets-encrypt"
+	// The other keys, as the ca.crt of a mesh, are kept.
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[corev1.TLSCertKey] = certificates.Certificate
+	secret.Data[corev1.TLSPrivateKeyKey] = certificates.PrivateKey
+
+	if exists {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	loggerOrDiscard(LE.Logger).Info("kubernetes secret written", "domain", fullDomainName,
+		"namespace", config.Namespace, "secret", name, "created", !exists)
+	return nil
+}
+
+// Return KubernetesClient, or a client built from the kubeconfig file or the in-cluster configuration.
+func (LE *LetsEncrypt) kubernetesClient(config KubernetesSecretConfig) (kubernetes.Interface, error) {
+	if LE.KubernetesClient != nil {
+		return LE.KubernetesClient, nil
+	}
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
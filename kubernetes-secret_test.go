@@ -0,0 +1,71 @@
+package lets_encrypt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteKubernetesSecret(t *testing.T) {
+	notAfter := time.Now().Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	resource := newTestResource(t, "*.example.com", notAfter)
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "wildcard.example.com",
+			Namespace:   "ingress",
+			Annotations: map[string]string{"team": "web"},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{corev1.TLSCertKey: []byte("old"), corev1.TLSPrivateKeyKey: []byte("old"), "ca.crt": []byte("ca")},
+	}
+	client := fake.NewClientset(existing)
+	LE := LetsEncrypt{KubernetesClient: client}
+	config := KubernetesSecretConfig{Namespace: "ingress"}
+
+	// Updated, the annotations and the other keys of someone else are kept.
+	if err := LE.writeKubernetesSecret(resource, "*.example.com", config); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	secret, err := client.CoreV1().Secrets("ingress").Get(context.Background(), "wildcard.example.com", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if string(secret.Data["ca.crt"]) != "ca" {
+		t.Error("Error: the other keys of the Secret weren't kept")
+	}
+	if string(secret.Data[corev1.TLSCertKey]) != string(resource.Certificate) ||
+		string(secret.Data[corev1.TLSPrivateKeyKey]) != string(resource.PrivateKey) {
+		t.Error("Error: the Secret data wasn't updated")
+	}
+	if secret.Annotations["team"] != "web" || secret.Annotations[AnnotationDomains] != "*.example.com" ||
+		secret.Annotations[AnnotationNotAfter] != notAfter.Format(time.RFC3339) {
+		t.Error("Error: unexpected annotations ", secret.Annotations)
+	}
+
+	// Created with the given name.
+	config.Name = "site-tls"
+	if err := LE.writeKubernetesSecret(resource, "*.example.com", config); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	created, err := client.CoreV1().Secrets("ingress").Get(context.Background(), "site-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if created.Type != corev1.SecretTypeTLS || len(created.Data[corev1.TLSCertKey]) == 0 {
+		t.Error("Error: the Secret wasn't created as a TLS Secret")
+	}
+
+	// A Secret of another type is never replaced.
+	opaque := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "ingress"}, Type: corev1.SecretTypeOpaque}
+	if _, err := client.CoreV1().Secrets("ingress").Create(context.Background(), opaque, metav1.CreateOptions{}); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	config.Name = "opaque"
+	if err := LE.writeKubernetesSecret(resource, "*.example.com", config); err == nil {
+		t.Error("Error: an Opaque Secret was overwritten")
+	}
+}
@@ -0,0 +1,88 @@
+package lets_encrypt
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// Wraps the DNSServer given to SetDNSProvider to observe every TXT record operation.
+type observedDNSServer struct {
+	dns.DNSServer
+	metrics *Metrics
+	logger  *slog.Logger
+}
+
+func newObservedDNSServer(dnsServer dns.DNSServer, metrics *Metrics, logger *slog.Logger) *observedDNSServer {
+	config := dnsServer.GetConfig()
+	logger = loggerOrDiscard(logger).With("provider", config.Type, "server", config.Name)
+	if loggingServer, ok := dnsServer.(dns.LoggingDNSServer); ok {
+		loggingServer.SetLogger(logger)
+	}
+	return &observedDNSServer{DNSServer: dnsServer, metrics: metrics, logger: logger}
+}
+
+func (s *observedDNSServer) AddTXTRecord(domain, name, value string) error {
+	err := s.DNSServer.AddTXTRecord(domain, name, value)
+	s.metrics.observeDNSOperation(s.GetConfig(), "add_txt_record", err)
+	if err != nil {
+		s.logger.Error("failed to add the challenge TXT record", "domain", domain, "record", name, "error", err)
+	} else {
+		s.logger.Info("challenge TXT record added", "domain", domain, "record", name)
+	}
+	return err
+}
+
+func (s *observedDNSServer) CleanTXTRecord(domain, name string) error {
+	err := s.DNSServer.CleanTXTRecord(domain, name)
+	s.metrics.observeDNSOperation(s.GetConfig(), "clean_txt_record", err)
+	if err != nil {
+		s.logger.Error("failed to clean the challenge TXT record", "domain", domain, "record", name, "error", err)
+	} else {
+		s.logger.Info("challenge TXT record cleaned", "domain", domain, "record", name)
+	}
+	return err
+}
+
+// Wraps the DNS provider given to lego. A record name is locked from the Present of a challenge to
+// the CleanUp of the same challenge, so concurrent orders don't remove the record of each other.
+// Lego cleans up every challenge it presented, even when Present failed: the lock is held until then.
+type lockedDNSProvider struct {
+	*dns.DNSProvider
+	records *keyedLocks
+
+	mutex sync.Mutex
+	// The key authorization of the challenge holding each record name.
+	holders map[string]string
+}
+
+func (p *lockedDNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, _ := dns01.GetRecord(domain, keyAuth)
+	p.records.Lock(fqdn)
+	p.mutex.Lock()
+	if p.holders == nil {
+		p.holders = make(map[string]string)
+	}
+	p.holders[fqdn] = keyAuth
+	p.mutex.Unlock()
+	return p.DNSProvider.Present(domain, token, keyAuth)
+}
+
+func (p *lockedDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, _ := dns01.GetRecord(domain, keyAuth)
+	p.mutex.Lock()
+	holding := p.holders[fqdn] == keyAuth
+	if holding {
+		delete(p.holders, fqdn)
+	}
+	p.mutex.Unlock()
+	// The record belongs to another challenge when this one was never presented.
+	if !holding {
+		return nil
+	}
+	defer p.records.Unlock(fqdn)
+	return p.DNSProvider.CleanUp(domain, token, keyAuth)
+}
@@ -0,0 +1,40 @@
+package lets_encrypt
+
+import (
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/notify"
+)
+
+// Send the event to the Notifier, if any. A failing notification is only logged, it must not
+// hide the result of the certificate request.
+func (LE *LetsEncrypt) notify(event notify.Event) {
+	if LE.Notifier == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if err := LE.Notifier.Notify(event); err != nil {
+		loggerOrDiscard(LE.Logger).Error("failed to send the notification",
+			"event", event.Type, "domain", event.Domain, "error", err)
+	}
+}
+
+// Notify every stored certificate expiring within the given duration.
+// Meant to be called on each run, behind a notify.Deduplicator to be told once per threshold.
+func (LE *LetsEncrypt) NotifyExpiries(within time.Duration) {
+	now := time.Now()
+	expiries, _ := certificatesExpiry(LE.certificateStorage())
+	for domain, notAfter := range expiries {
+		if notAfter.Sub(now) > within {
+			continue
+		}
+		LE.notify(notify.Event{
+			Type:     notify.EventExpiring,
+			Domain:   domain,
+			NotAfter: notAfter,
+			Time:     now,
+		})
+	}
+}
@@ -5,57 +5,131 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
-	"io/ioutil"
-	"os"
+	"log/slog"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
 )
 
 type LetsEncryptUserConfig struct {
 	Mail       string `mapstructure:"mail"`
 	AccountDir string `mapstructure:"account_path"`
+	// Optional, Let's Encrypt by default.
+	ACMEServer ACMEServerConfig `mapstructure:"acme_server"`
+	// Optional, the user stays silent without it.
+	Logger *slog.Logger `mapstructure:"-"`
+	// How long to wait for another process creating the account, DefaultLockTimeout when zero.
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	// Optional, the account key is written in plaintext without it.
+	KeyEncryption KeyEncryptionConfig `mapstructure:"key_encryption"`
+	// Optional, replaces AccountDir, see InitStorage.
+	Storage storage.StorageConfig `mapstructure:"storage"`
+	// Optional, shared with the other nodes: only one node creates the account in a remote Storage.
+	// Without it, two nodes starting at once on an empty Storage may both register an account.
+	Locker lock.Locker `mapstructure:"-"`
 }
 
 type LetsEncryptUser struct {
 	Email        string
 	Registration *registration.Resource
 	KeyPair      *ecdsa.PrivateKey
+	// Set instead of KeyPair for an account imported with an RSA key, as certbot creates them.
+	RSAKeyPair    *rsa.PrivateKey
+	ACMEServer    ACMEServerConfig
+	Logger        *slog.Logger
+	KeyEncryption KeyEncryptionConfig
 }
 
 // Init the Let's Encrypt user, if it' the first time, create every thing, and if the file already exist,
 // use the existing account.
 func InitLetsEncryptUser(config LetsEncryptUserConfig) (*LetsEncryptUser, error) {
 	newUser := LetsEncryptUser{
-		Email: config.Mail,
+		Email:         config.Mail,
+		ACMEServer:    config.ACMEServer,
+		Logger:        config.Logger,
+		KeyEncryption: config.KeyEncryption,
 	}
-	err := newUser.ReadExistingKeys(config.AccountDir)
+	logger := loggerOrDiscard(config.Logger).With("email", config.Mail, "account_dir", config.AccountDir)
+	store, err := accountStorage(config)
 	if err != nil {
+		return nil, err
+	}
+	if config.Storage.Type != "" {
+		logger = logger.With("storage", config.Storage.Type, "storage_path", config.Storage.Path)
+	}
+	// Only one process creates and registers the account, the others wait and read it.
+	if localStorage, ok := store.(storage.LocalStorage); ok {
+		lock, err := lockFile(localStorage.LocalPath("", lockFileName), true, config.LockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Unlock()
+	} else if config.Locker != nil {
+		locker := &LetsEncrypt{Locker: config.Locker, LockTimeout: config.LockTimeout, Logger: config.Logger}
+		unlock, _, err := locker.lockDistributed("accounts/" + config.Storage.Type + "/" + config.Storage.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	// Only a missing key makes a new account: a key that can't be read or decrypted is never replaced.
+	_, err = store.ReadFile("", "privKey.pem")
+	switch {
+	case storage.IsNotExist(err):
+		logger.Info("no existing account keys, creating a new account")
 		if err := newUser.CreateNewKeys(); err != nil {
 			return nil, err
 		}
-		if err := newUser.WriteKeys(config.AccountDir); err != nil {
+		if err := newUser.writeKeys(store); err != nil {
 			return nil, err
 		}
 		if err := newUser.RegisterAccount(); err != nil {
 			return nil, err
 		}
-		if err := newUser.SaveAccount(config.AccountDir); err != nil {
+		if err := newUser.saveAccount(store); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		logger.Error("failed to read the account key", "error", err)
+		return nil, err
+	default:
+		if err := newUser.readKeys(store); err != nil {
+			logger.Error("failed to read the account keys", "error", err)
 			return nil, err
 		}
 	}
-	if err := newUser.ReadExistingRegistration(config.AccountDir); err != nil {
+	if err := newUser.readRegistration(store); err != nil {
+		logger.Error("failed to read the account registration", "error", err)
 		return nil, err
 	}
+	logger.Debug("account loaded", "registration_uri", newUser.Registration.URI)
 	return &newUser, nil
 }
 
+// The storage of the account: the Storage of the config, AccountDir otherwise.
+func accountStorage(config LetsEncryptUserConfig) (storage.Storage, error) {
+	if config.Storage.Type != "" {
+		return InitStorage(config.Storage)
+	}
+	return file.NewFileStorage(config.AccountDir), nil
+}
+
 // Read the registration data from the json file saved before.
 func (u *LetsEncryptUser) ReadExistingRegistration(AccountDir string) error {
-	registrationBytes, err := ioutil.ReadFile(AccountDir + "/registration.json")
+	return u.readRegistration(file.NewFileStorage(AccountDir))
+}
+
+func (u *LetsEncryptUser) readRegistration(store storage.Storage) error {
+	registrationBytes, err := store.ReadFile("", "registration.json")
 	if err != nil {
 		return err
 	}
@@ -87,6 +161,9 @@ func (u LetsEncryptUser) GetRegistration() *registration.Resource {
 
 // Return crypto.PrivateKey.
 func (u *LetsEncryptUser) GetPrivateKey() crypto.PrivateKey {
+	if u.RSAKeyPair != nil {
+		return u.RSAKeyPair
+	}
 	return u.KeyPair
 }
 
@@ -96,13 +173,10 @@ func (u *LetsEncryptUser) SetPrivateKey(key *ecdsa.PrivateKey) {
 }
 
 // Creates a new ACME client via lego.NewConfig and give it the object LetsEncryptUser ,
-// use the URL to the Let's Encrypt staging or the production.
+// use the URL to the Let's Encrypt staging or the production, or the user's ACME server.
 // Create the
 func (u *LetsEncryptUser) RegisterAccount() error {
-	config := lego.NewConfig(u)
-	config.CADirURL = CADirURL
-	config.Certificate.KeyType = CertificateKeyType
-	client, err := lego.NewClient(config)
+	client, err := lego.NewClient(u.ACMEServer.legoConfig(u))
 	if err != nil {
 		return err
 	}
@@ -110,38 +184,45 @@ func (u *LetsEncryptUser) RegisterAccount() error {
 	// Register this new account to the ACME server.
 	u.Registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
 	if err != nil {
+		loggerOrDiscard(u.Logger).Error("failed to register the account", "email", u.Email, "error", err)
 		return err
 	}
+	loggerOrDiscard(u.Logger).Info("account registered", "email", u.Email, "registration_uri", u.Registration.URI)
 	return err
 }
 
 // Create a file named registration in json, marshal the registration and write it inside the file to save it.
 func (u *LetsEncryptUser) SaveAccount(AccountDir string) error {
+	return u.saveAccount(file.NewFileStorage(AccountDir))
+}
+
+func (u *LetsEncryptUser) saveAccount(store storage.Storage) error {
 	registrationBytes, err := json.Marshal(u.Registration)
 	if err != nil {
 		return err
 	}
-	registrationFile, err := os.Create(AccountDir + "/registration.json")
+	return store.WriteFile("", "registration.json", registrationBytes)
+}
+
+// Use the public and private key pair already saved.
+func (u *LetsEncryptUser) ReadExistingKeys(AccountDir string) error {
+	return u.readKeys(file.NewFileStorage(AccountDir))
+}
+
+func (u *LetsEncryptUser) readKeys(store storage.Storage) error {
+	storedPriv, err := store.ReadFile("", "privKey.pem")
 	if err != nil {
 		return err
 	}
-	_, err = registrationFile.Write(registrationBytes)
+	privString, err := u.KeyEncryption.decrypt(storedPriv)
 	if err != nil {
 		return err
 	}
-	if err := registrationFile.Close(); err != nil {
-		return err
-	}
-	return nil
-}
-
-// Use the public and private key pair already saved.
-func (u *LetsEncryptUser) ReadExistingKeys(AccountDir string) error {
-	privString, err := ioutil.ReadFile(AccountDir + "/privKey.pem")
-	if err != nil {
+	if block, _ := pem.Decode(privString); block != nil && block.Type == "RSA PRIVATE KEY" {
+		u.RSAKeyPair, err = x509.ParsePKCS1PrivateKey(block.Bytes)
 		return err
 	}
-	pubString, err := ioutil.ReadFile(AccountDir + "/pubKey.pem")
+	pubString, err := store.ReadFile("", "pubKey.pem")
 	if err != nil {
 		return err
 	}
@@ -161,37 +242,59 @@ func (u *LetsEncryptUser) CreateNewKeys() error {
 
 // Convert those key pair into string to be able to save them into files just created.
 func (u *LetsEncryptUser) WriteKeys(AccountDir string) error {
+	return u.writeKeys(file.NewFileStorage(AccountDir))
+}
+
+func (u *LetsEncryptUser) writeKeys(store storage.Storage) error {
+	if u.RSAKeyPair != nil {
+		return u.writeRSAKeys(store)
+	}
 
 	// Convert this pub and priv key to string.
 	stringPriv, err := convertX509PrivateKeyToString(u.KeyPair)
 	if err != nil {
 		return err
 	}
+	encryptedPriv, err := u.KeyEncryption.encrypt([]byte(stringPriv))
+	if err != nil {
+		return err
+	}
 	publicKey := &u.KeyPair.PublicKey
 	stringPub, err := convertX509PublicKeyToString(publicKey)
 	if err != nil {
 		return err
 	}
 
-	pvFile, err := os.Create(AccountDir + "/privKey.pem")
-	if err != nil {
+	if err := store.WriteFile("", "privKey.pem", encryptedPriv); err != nil {
 		return err
 	}
-	puFile, err := os.Create(AccountDir + "/pubKey.pem")
+	return store.WriteFile("", "pubKey.pem", []byte(stringPub))
+}
+
+// Write the RSA key as PKCS #1, the type of the PEM block tells it from an ECDSA key.
+func (u *LetsEncryptUser) writeRSAKeys(store storage.Storage) error {
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(u.RSAKeyPair)})
+	encryptedPriv, err := u.KeyEncryption.encrypt(privatePEM)
 	if err != nil {
 		return err
 	}
-	defer pvFile.Close()
-	defer puFile.Close()
-	_, err = pvFile.WriteString(stringPriv)
+	publicDER, err := x509.MarshalPKIXPublicKey(&u.RSAKeyPair.PublicKey)
 	if err != nil {
 		return err
 	}
-	_, err = puFile.WriteString(stringPub)
-	if err != nil {
+	if err := store.WriteFile("", "privKey.pem", encryptedPriv); err != nil {
 		return err
 	}
-	return nil
+	return store.WriteFile("", "pubKey.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+}
+
+// Implements slog.LogValuer so the account key never ends in the logs.
+func (u *LetsEncryptUser) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("email", u.Email)}
+	if u.Registration != nil {
+		attrs = append(attrs, slog.String("registration_uri", u.Registration.URI))
+	}
+	return slog.GroupValue(attrs...)
 }
 
 // Return the object LetsEncryptUser.
@@ -1,27 +1,105 @@
 package lets_encrypt
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
-	"os"
+	"k8s.io/client-go/kubernetes"
+	"log/slog"
+	"net/http"
+	"time"
 
+	"github.com/DumesnyJeremy/lets-encrypt/notify"
 	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
 )
 
 type LetsEncryptCertConfig struct {
 	CertificateDir string `mapstructure:"certificate_dir_path"`
+	// Optional, replaces CertificateDir, see InitStorage.
+	Storage     storage.StorageConfig `mapstructure:"storage"`
+	LockTimeout time.Duration         `mapstructure:"lock_timeout"`
+	// Optional, for several nodes sharing the certificates, see InitLocker.
+	DistributedLock lock.LockConfig               `mapstructure:"distributed_lock"`
+	KeyEncryption   KeyEncryptionConfig           `mapstructure:"key_encryption"`
+	Profiles        map[string]CertificateProfile `mapstructure:"profiles"`
+	Certificates    []CertificateConfig           `mapstructure:"certificates"`
+	// The endpoints serving the certificates, see MonitorEndpoints.
+	Monitor    MonitorConfig               `mapstructure:"monitor"`
+	Validation CertificateValidationConfig `mapstructure:"validation"`
+	CT         CTConfig                    `mapstructure:"certificate_transparency"`
+	// Optional, the HSM of the certificates with use_key_store.
+	KeyStore keystore.KeyStoreConfig `mapstructure:"key_store"`
+}
+
+// Settings of one certificate, looked up by domain name in LetsEncrypt.Certificates.
+type CertificateConfig struct {
+	Domain string `mapstructure:"domain"`
+	// Name of the profile in LetsEncrypt.Profiles, the settings of the certificate override it.
+	Profile            string `mapstructure:"profile"`
+	CertificateProfile `mapstructure:",squash"`
 }
 
 type LetsEncrypt struct {
 	Client               *lego.Client
 	User                 registration.User
 	CertificatesRootPath string
+	// Optional, where the certificates are kept instead of CertificatesRootPath.
+	Storage storage.Storage
+	// Optional, the client writing the Kubernetes Secrets, see KubernetesSecretConfig otherwise.
+	KubernetesClient kubernetes.Interface
+	// Optional settings per certificate, a domain without any gets the .crt and .key files only.
+	Certificates []CertificateConfig
+	// Named settings shared by the certificates, see CertificateConfig.Profile.
+	Profiles map[string]CertificateProfile
+	// Optional, must be set before SetDNSProvider to observe the DNS operations.
+	Metrics  *Metrics
+	Logger   *slog.Logger
+	Notifier notify.Notifier
+	// The ACME server the client was created for.
+	ACMEServer ACMEServerConfig
+	// When set, AskCertificate only checks the flow and writes nothing, see DryRunStaging and DryRunDNS.
+	DryRun string
+	// Optional, the staging directory of Let's Encrypt by default.
+	DryRunACMEServer ACMEServerConfig
+	// Optional, how DryRunDNS checks the record propagation, a lookup through the system resolver by default.
+	DryRunPropagationCheck dns01.PreCheckFunc
+
+	// Kept by SetDNSProvider for the dry-runs and the clients of the other ACME servers.
+	dnsProvider *lockedDNSProvider
+	dnsOptions  []dns01.ChallengeOption
+	// Clients of the ACME servers asked by the profiles, by directory URL.
+	clients *clientCache
+	// How long to wait for another process working on the same certificate, DefaultLockTimeout when zero.
+	LockTimeout time.Duration
+	// Optional, the .key files are written in plaintext without it.
+	KeyEncryption KeyEncryptionConfig
+	// Checks of the issued certificates, the chains verify to the system roots by default.
+	Validation CertificateValidationConfig
+	// Optional, the SCTs of the issued certificates are checked against a CT log list.
+	CT CTConfig
+	// Optional, keeps the keys of the certificates with UseKeyStore, see InitKeyStore.
+	KeyStore keystore.KeyStore
+	// Optional, shared with the other nodes: only one node orders a certificate at a time.
+	Locker lock.Locker
+	// How long the distributed locks are held without refresh, DefaultLockTTL when zero.
+	LockTTL time.Duration
+	// Only the node holding the leader lock runs the renewals of RunScheduler.
+	LeaderElection bool
+
+	// Keep concurrent issuances off the same directory and challenge record.
+	locks *issuanceLocks
+	// Told of the certificates written, see NewTLSCertificates. Set with locks, never replaced after.
+	watchers *certificateWatchers
 }
 
 const (
@@ -30,14 +108,36 @@ const (
 	CertificateKeyType = certcrypto.RSA2048
 )
 
+// Where and how to reach the ACME server, the Let's Encrypt directory at CADirURL by default.
+type ACMEServerConfig struct {
+	CADirURL string `mapstructure:"ca_dir_url"`
+	// Optional, to trust a private ACME server or go through a proxy.
+	HTTPClient *http.Client `mapstructure:"-"`
+}
+
+// Build the lego configuration for the user, on this ACME server.
+func (server ACMEServerConfig) legoConfig(user registration.User) *lego.Config {
+	config := lego.NewConfig(user)
+	config.CADirURL = CADirURL
+	if server.CADirURL != "" {
+		config.CADirURL = server.CADirURL
+	}
+	if server.HTTPClient != nil {
+		config.HTTPClient = server.HTTPClient
+	}
+	config.Certificate.KeyType = CertificateKeyType
+	return config
+}
+
 // Creates a new ACME client on behalf of the user.
 // The client will depend on the ACME directory located at CADirURL.
 func InitLetsEncrypt(CertificatesRootPath string, user registration.User) (LetsEncrypt, error) {
+	return InitLetsEncryptWithACMEServer(CertificatesRootPath, user, ACMEServerConfig{})
+}
 
-	leConfig := lego.NewConfig(user)
-	leConfig.CADirURL = CADirURL
-	leConfig.Certificate.KeyType = CertificateKeyType
-	client, err := lego.NewClient(leConfig)
+// Creates a new ACME client on behalf of the user, on the given ACME server.
+func InitLetsEncryptWithACMEServer(CertificatesRootPath string, user registration.User, server ACMEServerConfig) (LetsEncrypt, error) {
+	client, err := lego.NewClient(server.legoConfig(user))
 	if err != nil {
 		return LetsEncrypt{}, err
 	}
@@ -46,87 +146,250 @@ func InitLetsEncrypt(CertificatesRootPath string, user registration.User) (LetsE
 		CertificatesRootPath: CertificatesRootPath,
 		User:                 user,
 		Client:               client,
+		ACMEServer:           server,
+		clients:              &clientCache{byDirURL: make(map[string]*lego.Client)},
+		locks:                &issuanceLocks{},
+		watchers:             &certificateWatchers{},
 	}, nil
 }
 
+// Creates a new ACME client on behalf of the user with the settings of the config: its storage, distributed
+// lock and key store are opened here, the key store is to close once done. Monitor is given to MonitorEndpoints.
+func InitLetsEncryptWithConfig(config LetsEncryptCertConfig, user registration.User, server ACMEServerConfig) (LetsEncrypt, error) {
+	LE, err := InitLetsEncryptWithACMEServer(config.CertificateDir, user, server)
+	if err != nil {
+		return LetsEncrypt{}, err
+	}
+	LE.Profiles, LE.Certificates = config.Profiles, config.Certificates
+	LE.LockTimeout, LE.KeyEncryption = config.LockTimeout, config.KeyEncryption
+	LE.Validation, LE.CT = config.Validation, config.CT
+	if config.Storage.Type != "" || config.Storage.Path != "" {
+		if LE.Storage, err = InitStorage(config.Storage); err != nil {
+			return LetsEncrypt{}, err
+		}
+	}
+	if config.DistributedLock.Type != "" {
+		if LE.Locker, err = InitLocker(config.DistributedLock); err != nil {
+			return LetsEncrypt{}, err
+		}
+		LE.LockTTL, LE.LeaderElection = config.DistributedLock.TTL, config.DistributedLock.LeaderElection
+	}
+	if config.KeyStore.Type != "" {
+		if LE.KeyStore, err = InitKeyStore(config.KeyStore); err != nil {
+			return LetsEncrypt{}, err
+		}
+	}
+	return LE, nil
+}
+
 // SetDNS01Provider specifies a custom provider that can solve the given DNS-01 challenge.
-func (LE *LetsEncrypt) SetDNSProvider(dnsProvider dns.DNSProvider) error {
-	if err := LE.Client.Challenge.SetDNS01Provider(&dnsProvider); err != nil {
+// The options change how lego checks the TXT record propagation before asking for the validation.
+func (LE *LetsEncrypt) SetDNSProvider(dnsProvider dns.DNSProvider, options ...dns01.ChallengeOption) error {
+	if LE.locks == nil {
+		LE.locks = &issuanceLocks{}
+	}
+	if LE.watchers == nil {
+		LE.watchers = &certificateWatchers{}
+	}
+	dnsProvider.DNSServer = newObservedDNSServer(dnsProvider.DNSServer, LE.Metrics, LE.Logger)
+	provider := &lockedDNSProvider{DNSProvider: &dnsProvider, records: &LE.locks.records}
+	if err := LE.Client.Challenge.SetDNS01Provider(provider, options...); err != nil {
 		return err
 	}
+	LE.dnsProvider, LE.dnsOptions = provider, options
 	return nil
 }
 
 // Tries to obtain a certificate using all domains passed into it.
+// With DryRun set, the flow is only checked and nothing is written.
 func (LE *LetsEncrypt) AskCertificate(fullDomainName string) error {
-	request := certificate.ObtainRequest{
-		Domains: []string{fullDomainName},
-		Bundle:  true,
-	}
-	certificates, err := LE.Client.Certificate.Obtain(request)
+	return LE.obtainCertificate(fullDomainName, false)
+}
+
+// Returned by askCertificate for a renewal done by another node while waiting for the lock.
+var errAlreadyRenewed = errors.New("The certificate was already renewed.")
+
+// Obtain the certificate of the domain. A renewal is skipped once the lock is held, when the
+// stored certificate is out of its renewal window.
+func (LE *LetsEncrypt) obtainCertificate(fullDomainName string, renewal bool) error {
+	fullDomainName, err := dns.ToASCII(fullDomainName)
 	if err != nil {
 		return err
 	}
-	if err := LE.addCertificateIntoFolder(certificates, fullDomainName); err != nil {
+	if LE.DryRun != "" {
+		return LE.dryRun(fullDomainName)
+	}
+	if LE.locks != nil {
+		LE.locks.directories.Lock(fullDomainName)
+		defer LE.locks.directories.Unlock(fullDomainName)
+	}
+	logger := loggerOrDiscard(LE.Logger).With("domain", fullDomainName)
+	if unicodeDomain := dns.ToUnicode(fullDomainName); unicodeDomain != fullDomainName {
+		logger = logger.With("unicode_domain", unicodeDomain)
+	}
+	logger.Info("asking certificate")
+	start := time.Now()
+	err = LE.askCertificate(fullDomainName, renewal)
+	if errors.Is(err, errAlreadyRenewed) {
+		logger.Info("certificate already renewed by another node")
+		return nil
+	}
+	LE.Metrics.observeCertificateRequest(start, err)
+	if err != nil {
+		logger.Error("failed to obtain certificate", "problem_type", acmeProblemType(err), "error", err)
+		LE.notify(notify.Event{Type: notify.EventObtainFailed, Domain: fullDomainName, Error: err.Error()})
 		return err
 	}
+	logger.Info("certificate obtained", "duration", time.Since(start))
+	LE.notify(notify.Event{Type: notify.EventObtained, Domain: fullDomainName})
 	return nil
 }
 
-// Split the certificate in two, the key and the certificate to write them in different files.
-func (LE *LetsEncrypt) addCertificateIntoFolder(certificates *certificate.Resource, fullDomainName string) error {
-	nameFolder := LE.CertificatesRootPath + "/" + fullDomainName
-	if _, err := os.Stat(nameFolder); os.IsNotExist(err) {
-		if err := os.Mkdir(nameFolder, os.ModePerm); err != nil {
-			return err
+func (LE *LetsEncrypt) askCertificate(fullDomainName string, renewal bool) error {
+	config, err := LE.certificateConfig(fullDomainName)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputFormats(config.OutputFormats, LE.KeyEncryption); err != nil {
+		return err
+	}
+	unlock, lost, err := LE.lockCertificate(fullDomainName, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store := LE.certificateStorage()
+	if renewal {
+		stored, err := readStoredCertificate(store, fullDomainName)
+		if err == nil && time.Until(stored.NotAfter) > config.RenewBefore {
+			return errAlreadyRenewed
 		}
 	}
-	PrivateKeyFile, err := os.Create(nameFolder + "/" + fullDomainName + ".key")
+	client, err := LE.clientFor(config.ACMEServer)
 	if err != nil {
 		return err
 	}
-	CertifFile, err := os.Create(nameFolder + "/" + fullDomainName + ".crt")
+	metadata, err := readMetadata(store, fullDomainName)
 	if err != nil {
 		return err
 	}
-
-	if err := writeCertifIntoFile(PrivateKeyFile, CertifFile, certificates); err != nil {
+	request := certificate.ObtainRequest{
+		Domains:        []string{fullDomainName},
+		Bundle:         true,
+		PreferredChain: config.PreferredChain,
+	}
+	var certificates *certificate.Resource
+	var publicKey crypto.PublicKey
+	var newKey bool
+	if config.usesKeyStore() {
+		certificates, publicKey, newKey, err = LE.obtainWithKeyStore(client, config, request)
+		if err != nil {
+			return err
+		}
+		reference := LE.KeyStore.Reference(fullDomainName)
+		metadata.KeyReference = &reference
+	} else {
+		request.PrivateKey, err = LE.reusablePrivateKey(store, config, &metadata)
+		if err != nil {
+			return err
+		}
+		newKey = request.PrivateKey == nil
+		if newKey && config.KeyType != "" {
+			request.PrivateKey, err = certcrypto.GeneratePrivateKey(certcrypto.KeyType(config.KeyType))
+			if err != nil {
+				return err
+			}
+		}
+		certificates, err = client.Certificate.Obtain(request)
+		if err != nil {
+			return err
+		}
+		metadata.KeyReference = nil
+	}
+	if err := LE.Validation.validate(certificates, request.Domains, publicKey, time.Now()); err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func writeCertifIntoFile(PrivateKeyFile *os.File, CertifFile *os.File, certificates *certificate.Resource) error {
-
-	// Clear file
-	if err := PrivateKeyFile.Truncate(0); err != nil {
+	ctResult, err := LE.CT.check(certificates.Certificate, time.Now())
+	if err != nil {
+		return err
+	}
+	if ctResult != nil && !ctResult.Compliant {
+		if LE.CT.Enforce {
+			return &CTError{Domain: fullDomainName, Result: ctResult}
+		}
+		loggerOrDiscard(LE.Logger).Warn("certificate not compliant with the CT policy", "domain", fullDomainName,
+			"policy", ctResult.Policy, "valid_scts", ctResult.ValidSCTs, "required", ctResult.Required, "operators", ctResult.Operators)
+	}
+	// Lego doesn't expose the order URL, the certificate URL is the closest to it.
+	loggerOrDiscard(LE.Logger).Debug("certificate issued", "domain", fullDomainName,
+		"certificate_url", certificates.CertURL, "certificate_stable_url", certificates.CertStableURL)
+	metadata.ObtainedAt = time.Now()
+	metadata.UnicodeDomain = dns.ToUnicode(fullDomainName)
+	metadata.CT = ctResult
+	if newKey {
+		metadata.KeyCreatedAt = metadata.ObtainedAt
+	}
+	// Another node may hold the lock once it was lost, it writes the certificate then.
+	if err := checkLease(lost); err != nil {
+		return err
+	}
+	if err := LE.addCertificateIntoFolder(store, certificates, fullDomainName, config, metadata); err != nil {
+		return err
+	}
+	// Served at once, even when the Secret or a hook fails next. The key is nil for a key store.
+	LE.watchers.written(fullDomainName, certificates.Certificate, certificates.PrivateKey)
+	if err := checkLease(lost); err != nil {
 		return err
 	}
-	if err := CertifFile.Truncate(0); err != nil {
+	if err := LE.writeKubernetesSecret(certificates, fullDomainName, config.KubernetesSecret); err != nil {
 		return err
 	}
+	return LE.runHooks(store, config, lost)
+}
 
-	// Write into file
-	_, err := PrivateKeyFile.Write(certificates.PrivateKey)
+// Split the certificate in two, the key and the certificate to write them in different files, written
+// along with the output formats and the metadata, at once when the storage supports it.
+func (LE *LetsEncrypt) addCertificateIntoFolder(store storage.Storage, certificates *certificate.Resource, fullDomainName string, config CertificateConfig, metadata CertificateMetadata) error {
+	files, err := outputFormatFiles(fullDomainName, certificates, config.OutputFormats)
 	if err != nil {
 		return err
 	}
-	_, err = CertifFile.Write(certificates.Certificate)
+	// The key of a key store stays in it, the metadata has its reference.
+	if metadata.KeyReference == nil {
+		files[fullDomainName+".key"], err = LE.KeyEncryption.encrypt(certificates.PrivateKey)
+		if err != nil {
+			return err
+		}
+	}
+	files[fullDomainName+".crt"] = certificates.Certificate
+	files[fullDomainName+".json"], err = encodeMetadata(metadata)
 	if err != nil {
 		return err
 	}
-
-	if err := CertifFile.Close(); err != nil {
+	if err := storage.WriteFiles(store, fullDomainName, files); err != nil {
 		return err
 	}
-	if err := PrivateKeyFile.Close(); err != nil {
-		return err
+	// The key of a previous version not in the key store. The metadata written first tells the readers
+	// not to use it meanwhile.
+	if metadata.KeyReference != nil {
+		if err := storage.RemoveFile(store, fullDomainName, fullDomainName+".key"); err != nil {
+			return err
+		}
 	}
+	loggerOrDiscard(LE.Logger).Debug("certificate files written", "domain", fullDomainName)
 
 	return nil
 }
 
+// Read the certificate of the domain from the storage.
+func readStoredCertificate(store storage.Storage, fullDomainName string) (*x509.Certificate, error) {
+	certificateBytes, err := store.ReadFile(fullDomainName, fullDomainName+".crt")
+	if err != nil {
+		return nil, err
+	}
+	return certcrypto.ParsePEMCertificate(certificateBytes)
+}
+
 // Take the Private and Public string Key in arg and return the 2 ecdsa Keys.
 func stringToKey(pemEncodedPriv string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	blockPriv, _ := pem.Decode([]byte(pemEncodedPriv))
@@ -0,0 +1,71 @@
+package lets_encrypt
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	legolog "github.com/go-acme/lego/v4/log"
+)
+
+// Used when no logger is given, the library stays silent.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// Return the logger, or a logger discarding everything if none is set.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
+
+// Send the logs of lego, written on stdout by default, to the given logger.
+// Lego only has a global logger, so this affects every client of the process.
+func SetLegoLogger(logger *slog.Logger) {
+	legolog.Logger = legoLogger{logger: loggerOrDiscard(logger).With("component", "lego")}
+}
+
+// Implements the lego StdLogger, the "[INFO]" and "[WARN]" prefixes of lego become levels.
+type legoLogger struct {
+	logger *slog.Logger
+}
+
+func (l legoLogger) log(message string) {
+	message = strings.TrimSuffix(message, "\n")
+	switch {
+	case strings.HasPrefix(message, "[WARN] "):
+		l.logger.Warn(strings.TrimPrefix(message, "[WARN] "))
+	case strings.HasPrefix(message, "[INFO] "):
+		l.logger.Info(strings.TrimPrefix(message, "[INFO] "))
+	default:
+		l.logger.Info(message)
+	}
+}
+
+func (l legoLogger) Fatal(args ...interface{}) {
+	l.logger.Error(fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l legoLogger) Fatalln(args ...interface{}) {
+	l.logger.Error(fmt.Sprintln(args...))
+	os.Exit(1)
+}
+
+func (l legoLogger) Fatalf(format string, args ...interface{}) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l legoLogger) Print(args ...interface{}) {
+	l.log(fmt.Sprint(args...))
+}
+
+func (l legoLogger) Println(args ...interface{}) {
+	l.log(fmt.Sprintln(args...))
+}
+
+func (l legoLogger) Printf(format string, args ...interface{}) {
+	l.log(fmt.Sprintf(format, args...))
+}
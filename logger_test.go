@@ -0,0 +1,63 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+type fakeDNSServer struct {
+	config  dns.DNSServerConfig
+	records map[string]string
+}
+
+func (f *fakeDNSServer) IsAuthoritativeForDomain(domain string) bool { return true }
+func (f *fakeDNSServer) GetConfig() dns.DNSServerConfig              { return f.config }
+func (f *fakeDNSServer) AddTXTRecord(domain, name, value string) error {
+	f.records[name] = value
+	return nil
+}
+func (f *fakeDNSServer) CleanTXTRecord(domain, name string) error {
+	delete(f.records, name)
+	return nil
+}
+
+func TestObservedDNSServerNeverLogsAPIKey(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	config := dns.DNSServerConfig{Name: "ns1", Type: "pdns", APIKey: "secret-api-key"}
+	server := newObservedDNSServer(&fakeDNSServer{config: config, records: map[string]string{}}, nil, logger)
+
+	if err := server.AddTXTRecord("example.com", "_acme-challenge.example.com.", "\"token\""); err != nil {
+		t.Error("Error: ", err)
+	}
+	logger.Info("provider configured", "config", config)
+
+	output := buffer.String()
+	if strings.Contains(output, "secret-api-key") {
+		t.Error("Error: the API key was logged")
+	}
+	for _, expected := range []string{`"provider":"pdns"`, `"record":"_acme-challenge.example.com."`, `"domain":"example.com"`} {
+		if !strings.Contains(output, expected) {
+			t.Error("Error: missing attribute ", expected)
+		}
+	}
+}
+
+func TestLegoLoggerLevels(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := legoLogger{logger: slog.New(slog.NewTextHandler(&buffer, nil))}
+	logger.Printf("[WARN] [%s] acme: %s", "example.com", "retrying")
+	logger.Printf("[INFO] [%s] acme: Obtaining bundled SAN certificate", "example.com")
+
+	output := buffer.String()
+	if !strings.Contains(output, `level=WARN msg="[example.com] acme: retrying"`) {
+		t.Error("Error: the warning wasn't logged as such: ", output)
+	}
+	if !strings.Contains(output, `level=INFO msg="[example.com] acme: Obtaining bundled SAN certificate"`) {
+		t.Error("Error: the info wasn't logged as such: ", output)
+	}
+}
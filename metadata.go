@@ -0,0 +1,42 @@
+package lets_encrypt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Kept in <root>/<domain>/<domain>.json next to the certificate, for what the PEM files can't tell.
+type CertificateMetadata struct {
+	Domain string `json:"domain"`
+	// The domain as its owners write it, the same as Domain unless it is an IDN.
+	UnicodeDomain string `json:"unicode_domain,omitempty"`
+	// When the certificate was last obtained.
+	ObtainedAt time.Time `json:"obtained_at"`
+	// When the private key was generated, older than the certificate when the key is reused.
+	KeyCreatedAt time.Time `json:"key_created_at"`
+	// The SCTs checked when the certificate was obtained, with a CT log list only.
+	CT *CTResult `json:"certificate_transparency,omitempty"`
+	// Where the private key is kept, for a key of a key store, which has no .key file.
+	KeyReference *keystore.KeyReference `json:"key_reference,omitempty"`
+}
+
+// Read the metadata of a certificate, empty metadata is returned when the file doesn't exist.
+func readMetadata(store storage.Storage, fullDomainName string) (CertificateMetadata, error) {
+	metadata := CertificateMetadata{Domain: fullDomainName}
+	metadataBytes, err := store.ReadFile(fullDomainName, fullDomainName+".json")
+	if storage.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(metadataBytes, &metadata)
+	return metadata, err
+}
+
+func encodeMetadata(metadata CertificateMetadata) ([]byte, error) {
+	return json.MarshalIndent(metadata, "", "  ")
+}
@@ -0,0 +1,229 @@
+package lets_encrypt
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
+)
+
+const metricsNamespace = "lets_encrypt"
+
+// ACME problem type prefix, trimmed from the "problem_type" label.
+const acmeErrorNamespace = "urn:ietf:params:acme:error:"
+
+// Metrics holds every Prometheus metric of the library: certificate requests, DNS record
+// operations and the expiry of the certificates stored under CertificatesRootPath.
+type Metrics struct {
+	CertificatesRootPath string
+	// Optional, where the certificates are read instead of CertificatesRootPath.
+	Storage storage.Storage
+
+	requests       prometheus.Counter
+	successes      prometheus.Counter
+	failures       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	dnsCalls       *prometheus.CounterVec
+	dnsErrors      *prometheus.CounterVec
+	endpointExpiry *prometheus.GaugeVec
+	endpointDrift  *prometheus.GaugeVec
+	expiryDesc     *prometheus.Desc
+	scanErrorDesc  *prometheus.Desc
+}
+
+// Create the metrics, the expiry gauge is computed on each collect from the certificates
+// found in CertificatesRootPath.
+func NewMetrics(CertificatesRootPath string) *Metrics {
+	return &Metrics{
+		CertificatesRootPath: CertificatesRootPath,
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_requests_total",
+			Help:      "Number of certificate requests made through AskCertificate.",
+		}),
+		successes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_requests_succeeded_total",
+			Help:      "Number of certificates obtained and written successfully.",
+		}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_requests_failed_total",
+			Help:      "Number of failed certificate requests by ACME problem type.",
+		}, []string{"problem_type"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "certificate_request_duration_seconds",
+			Help:      "Duration of certificate requests, from the order to the files written.",
+			Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+		}, []string{"result"}),
+		dnsCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dns_operations_total",
+			Help:      "Number of TXT record operations made on the DNS servers.",
+		}, []string{"provider", "server", "operation"}),
+		dnsErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dns_operation_errors_total",
+			Help:      "Number of TXT record operations that returned an error.",
+		}, []string{"provider", "server", "operation"}),
+		endpointExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "endpoint_certificate_expiry_seconds",
+			Help:      "Seconds until the certificate served by the endpoint expires, at its last check.",
+		}, []string{"endpoint", "server_name"}),
+		endpointDrift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "endpoint_certificate_drift",
+			Help:      "1 when the endpoint served another certificate than the stored one at its last check.",
+		}, []string{"endpoint", "server_name"}),
+		expiryDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "certificate_expiry_seconds"),
+			"Seconds until the stored certificate expires, negative once expired.",
+			[]string{"domain"}, nil,
+		),
+		scanErrorDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "certificate_scan_errors"),
+			"Number of stored certificates that could not be read during the last collect.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.successes.Describe(ch)
+	m.failures.Describe(ch)
+	m.duration.Describe(ch)
+	m.dnsCalls.Describe(ch)
+	m.dnsErrors.Describe(ch)
+	m.endpointExpiry.Describe(ch)
+	m.endpointDrift.Describe(ch)
+	ch <- m.expiryDesc
+	ch <- m.scanErrorDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.successes.Collect(ch)
+	m.failures.Collect(ch)
+	m.duration.Collect(ch)
+	m.dnsCalls.Collect(ch)
+	m.dnsErrors.Collect(ch)
+	m.endpointExpiry.Collect(ch)
+	m.endpointDrift.Collect(ch)
+
+	store := m.Storage
+	if store == nil {
+		store = file.NewFileStorage(m.CertificatesRootPath)
+	}
+	expiries, scanErrors := certificatesExpiry(store)
+	for domain, notAfter := range expiries {
+		ch <- prometheus.MustNewConstMetric(m.expiryDesc, prometheus.GaugeValue,
+			time.Until(notAfter).Seconds(), domain)
+	}
+	ch <- prometheus.MustNewConstMetric(m.scanErrorDesc, prometheus.GaugeValue, float64(scanErrors))
+}
+
+// Write every metric into a file in the Prometheus text format, to be picked up by the
+// node_exporter textfile collector. The file is written atomically.
+func (m *Metrics) WriteTextfile(filename string) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(m); err != nil {
+		return err
+	}
+	return prometheus.WriteToTextfile(filename, registry)
+}
+
+// Record the result of a certificate request started at start.
+func (m *Metrics) observeCertificateRequest(start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.requests.Inc()
+	if err != nil {
+		m.failures.WithLabelValues(acmeProblemType(err)).Inc()
+		m.duration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		return
+	}
+	m.successes.Inc()
+	m.duration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+}
+
+// Record a TXT record operation made on the given DNS server.
+func (m *Metrics) observeDNSOperation(config dns.DNSServerConfig, operation string, err error) {
+	if m == nil {
+		return
+	}
+	m.dnsCalls.WithLabelValues(config.Type, config.Name, operation).Inc()
+	if err != nil {
+		m.dnsErrors.WithLabelValues(config.Type, config.Name, operation).Inc()
+	}
+}
+
+// Record what the endpoint served. The expiry is computed at the check, not at the collect.
+func (m *Metrics) observeEndpoint(result MonitorResult) {
+	if m == nil {
+		return
+	}
+	labels := []string{result.Target.Address, result.Target.ServerName}
+	m.endpointExpiry.WithLabelValues(labels...).Set(time.Until(result.NotAfter).Seconds())
+	drift := 0.0
+	if result.Drifted() {
+		drift = 1
+	}
+	m.endpointDrift.WithLabelValues(labels...).Set(drift)
+}
+
+// Find the ACME problem type behind err. Lego reports the failures per domain in a map,
+// so the map values are searched too. Returns "none" when the error doesn't come from the CA.
+func acmeProblemType(err error) string {
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) && problem.Type != "" {
+		return strings.TrimPrefix(problem.Type, acmeErrorNamespace)
+	}
+	value := reflect.ValueOf(err)
+	if value.Kind() == reflect.Map {
+		iter := value.MapRange()
+		for iter.Next() {
+			if domainErr, ok := iter.Value().Interface().(error); ok {
+				if problemType := acmeProblemType(domainErr); problemType != "none" {
+					return problemType
+				}
+			}
+		}
+	}
+	return "none"
+}
+
+// Read the certificate of every directory of the storage and return their expiry date by domain,
+// along with the number of certificates that couldn't be read.
+func certificatesExpiry(store storage.Storage) (map[string]time.Time, int) {
+	expiries := make(map[string]time.Time)
+	dirs, err := store.ListDirs()
+	if err != nil {
+		return expiries, 1
+	}
+	scanErrors := 0
+	for _, dir := range dirs {
+		cert, err := readStoredCertificate(store, dir)
+		if storage.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			scanErrors++
+			continue
+		}
+		expiries[dir] = cert.NotAfter
+	}
+	return expiries, scanErrors
+}
@@ -0,0 +1,113 @@
+package lets_encrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// Create a self-signed certificate for domain, expiring at notAfter, as lego would return it.
+func newTestResource(t *testing.T, domain string, notAfter time.Time) *certificate.Resource {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return &certificate.Resource{
+		Domain:      domain,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  certcrypto.PEMEncode(key),
+	}
+}
+
+// Write a self-signed certificate for domain, expiring at notAfter, the way addCertificateIntoFolder does.
+func writeTestCertificate(t *testing.T, root, domain string, notAfter time.Time) {
+	resource := newTestResource(t, domain, notAfter)
+	if err := os.MkdirAll(filepath.Join(root, domain), os.ModePerm); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, domain, domain+".crt"), resource.Certificate, 0644); err != nil {
+		t.Fatal("Error: ", err)
+	}
+}
+
+func TestAcmeProblemType(t *testing.T) {
+	problem := &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:rateLimited"}
+	if problemType := acmeProblemType(problem); problemType != "rateLimited" {
+		t.Error("Error: wrong problem type ", problemType)
+	}
+	// Lego returns the per domain failures as a map of errors.
+	perDomain := map[string]error{"example.com": &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:dns"}}
+	if problemType := acmeProblemType(mapError(perDomain)); problemType != "dns" {
+		t.Error("Error: wrong problem type ", problemType)
+	}
+	if problemType := acmeProblemType(errors.New("network down")); problemType != "none" {
+		t.Error("Error: wrong problem type ", problemType)
+	}
+}
+
+type mapError map[string]error
+
+func (e mapError) Error() string { return "one or more domains had a problem" }
+
+func TestMetricsTextfile(t *testing.T) {
+	root, err := ioutil.TempDir("", "certificates")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer os.RemoveAll(root)
+	writeTestCertificate(t, root, "example.com", time.Now().Add(48*time.Hour))
+
+	metrics := NewMetrics(root)
+	metrics.observeCertificateRequest(time.Now(), nil)
+	metrics.observeCertificateRequest(time.Now(), &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:rateLimited"})
+	metrics.observeDNSOperation(dns.DNSServerConfig{Name: "ns1", Type: "pdns"}, "add_txt_record", errors.New("timeout"))
+
+	textfile := filepath.Join(root, "lets_encrypt.prom")
+	if err := metrics.WriteTextfile(textfile); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	content, err := ioutil.ReadFile(textfile)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	for _, expected := range []string{
+		"lets_encrypt_certificate_requests_total 2",
+		"lets_encrypt_certificate_requests_succeeded_total 1",
+		`lets_encrypt_certificate_requests_failed_total{problem_type="rateLimited"} 1`,
+		`lets_encrypt_dns_operation_errors_total{operation="add_txt_record",provider="pdns",server="ns1"} 1`,
+		`lets_encrypt_certificate_expiry_seconds{domain="example.com"}`,
+		"lets_encrypt_certificate_scan_errors 0",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Error("Error: missing metric ", expected)
+		}
+	}
+}
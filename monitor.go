@@ -0,0 +1,171 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/notify"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// How long to wait for the handshake of an endpoint when the config sets no timeout.
+const DefaultMonitorTimeout = 10 * time.Second
+
+// A TLS endpoint serving one of the certificates.
+type MonitorTarget struct {
+	// host:port to dial.
+	Address string `mapstructure:"address"`
+	// Optional, the SNI sent, the host of Address by default.
+	ServerName string `mapstructure:"server_name"`
+	// Optional, the stored certificate expected, ServerName by default.
+	Domain string `mapstructure:"domain"`
+}
+
+type MonitorConfig struct {
+	Targets []MonitorTarget `mapstructure:"targets"`
+	// How long to wait for each handshake, DefaultMonitorTimeout when zero.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Renew the stored certificate when the served one is within its renewal window. The renewal
+	// is skipped when the stored one is already renewed: the endpoint only needs a reload.
+	Renew bool `mapstructure:"renew"`
+	// Optional, the roots verifying the served chains, the system roots by default.
+	RootCAs *x509.CertPool `mapstructure:"-"`
+}
+
+// What an endpoint serves.
+type MonitorResult struct {
+	Target MonitorTarget
+	// The dial or the handshake failed, nothing else is set.
+	Err error
+	// The served certificate first, then its chain as sent.
+	Chain    []*x509.Certificate
+	NotAfter time.Time
+	// Why the served chain doesn't verify for ServerName, nil when it does.
+	ChainErr error
+	// The served certificate is the stored one. When it isn't and the stored one exists, the
+	// endpoint drifted: it wasn't reloaded after a renewal.
+	Matches        bool
+	StoredNotAfter time.Time
+	// Set when Renew asked for the certificate and failed.
+	RenewErr error
+}
+
+// The endpoint wasn't reloaded after a renewal: it doesn't serve the stored certificate.
+func (r MonitorResult) Drifted() bool {
+	return !r.Matches && !r.StoredNotAfter.IsZero()
+}
+
+func (target MonitorTarget) withDefaults() MonitorTarget {
+	if target.ServerName == "" {
+		target.ServerName, _, _ = net.SplitHostPort(target.Address)
+	}
+	if target.Domain == "" {
+		target.Domain = target.ServerName
+	}
+	// The SNI and the stored certificates use the ASCII form of the IDNs.
+	if serverName, err := dns.ToASCII(target.ServerName); err == nil {
+		target.ServerName = serverName
+	}
+	if domain, err := dns.ToASCII(target.Domain); err == nil {
+		target.Domain = domain
+	}
+	return target
+}
+
+// Dial every target, compare what it serves to the stored certificates and report the drifts to
+// the Notifier. The results are in the order of the targets.
+func (LE *LetsEncrypt) MonitorEndpoints(config MonitorConfig) []MonitorResult {
+	results := make([]MonitorResult, len(config.Targets))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < DefaultWorkers && i < len(config.Targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = LE.monitorEndpoint(config, config.Targets[index].withDefaults())
+			}
+		}()
+	}
+	for index := range config.Targets {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+func (LE *LetsEncrypt) monitorEndpoint(config MonitorConfig, target MonitorTarget) MonitorResult {
+	logger := loggerOrDiscard(LE.Logger).With("endpoint", target.Address, "server_name", target.ServerName)
+	result := MonitorResult{Target: target}
+	result.Chain, result.Err = servedChain(target, config.Timeout)
+	if result.Err != nil {
+		logger.Error("failed to reach the endpoint", "error", result.Err)
+		return result
+	}
+	served := result.Chain[0]
+	result.NotAfter = served.NotAfter
+	result.ChainErr = verifyServedChain(result.Chain, target.ServerName, config.RootCAs)
+
+	stored, err := readStoredCertificate(LE.certificateStorage(), target.Domain)
+	if err == nil {
+		result.StoredNotAfter = stored.NotAfter
+		result.Matches = bytes.Equal(stored.Raw, served.Raw)
+	} else if !storage.IsNotExist(err) {
+		logger.Error("failed to read the stored certificate", "domain", target.Domain, "error", err)
+	}
+	LE.Metrics.observeEndpoint(result)
+	logger.Info("endpoint checked", "not_after", result.NotAfter, "matches", result.Matches,
+		"chain_error", result.ChainErr)
+	if result.Drifted() {
+		logger.Warn("endpoint serves another certificate than the stored one", "domain", target.Domain,
+			"stored_not_after", result.StoredNotAfter)
+		LE.notify(notify.Event{Type: notify.EventDrift, Domain: target.Domain, Endpoint: target.Address, NotAfter: result.NotAfter})
+	}
+
+	if config.Renew {
+		certificateConfig, err := LE.certificateConfig(target.Domain)
+		if err == nil && time.Until(result.NotAfter) <= certificateConfig.RenewBefore {
+			err = LE.obtainCertificate(target.Domain, true)
+		}
+		result.RenewErr = err
+	}
+	return result
+}
+
+// Return the certificates sent by the endpoint. They aren't verified during the handshake, to
+// report a broken chain as well.
+func servedChain(target MonitorTarget, timeout time.Duration) ([]*x509.Certificate, error) {
+	if timeout <= 0 {
+		timeout = DefaultMonitorTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", target.Address, &tls.Config{
+		ServerName:         target.ServerName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("No certificate served by " + target.Address + ".")
+	}
+	return chain, nil
+}
+
+func verifyServedChain(chain []*x509.Certificate, serverName string, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates, Roots: roots})
+	return err
+}
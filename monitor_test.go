@@ -0,0 +1,95 @@
+package lets_encrypt
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/notify"
+)
+
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestMonitorEndpoints(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, acmeServer := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	notifier := &recordingNotifier{}
+	LE.Notifier = notifier
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	nameFolder := filepath.Join(LE.CertificatesRootPath, "www.example.com")
+	certificatePEM, _ := ioutil.ReadFile(filepath.Join(nameFolder, "www.example.com.crt"))
+	keyPEM, _ := ioutil.ReadFile(filepath.Join(nameFolder, "www.example.com.key"))
+	pair, err := tls.X509KeyPair(certificatePEM, keyPEM)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	endpoint := httptest.NewUnstartedServer(http.NotFoundHandler())
+	endpoint.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	endpoint.StartTLS()
+	defer endpoint.Close()
+	address := endpoint.Listener.Addr().String()
+
+	config := MonitorConfig{
+		Targets: []MonitorTarget{
+			{Address: address, ServerName: "www.example.com"},
+			{Address: address, ServerName: "other.example.com", Domain: "www.example.com"},
+			{Address: "127.0.0.1:1", ServerName: "www.example.com"},
+		},
+		Timeout: time.Second,
+		RootCAs: acmeServer.Roots(),
+	}
+	results := LE.MonitorEndpoints(config)
+	if results[0].Err != nil || !results[0].Matches || results[0].ChainErr != nil || len(results[0].Chain) != 2 {
+		t.Error("Error: the endpoint serves the stored certificate ", results[0])
+	}
+	if results[0].NotAfter != pair.Leaf.NotAfter {
+		t.Error("Error: wrong expiry ", results[0].NotAfter)
+	}
+	if results[1].ChainErr == nil {
+		t.Error("Error: the certificate isn't valid for other.example.com")
+	}
+	if results[2].Err == nil {
+		t.Error("Error: the closed port was reached")
+	}
+	for _, event := range notifier.events {
+		if event.Type == notify.EventDrift {
+			t.Error("Error: no drift expected yet ", event)
+		}
+	}
+
+	// The served certificate is in its renewal window: the stored one is renewed, but the endpoint
+	// isn't reloaded.
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{RenewBefore: 1000 * 24 * time.Hour}}}
+	config.Targets, config.Renew = config.Targets[:1], true
+	results = LE.MonitorEndpoints(config)
+	if results[0].RenewErr != nil {
+		t.Fatal("Error: ", results[0].RenewErr)
+	}
+	renewed, err := readCertificateFile(filepath.Join(nameFolder, "www.example.com.crt"))
+	if err != nil || renewed.Equal(pair.Leaf) {
+		t.Fatal("Error: the certificate wasn't renewed ", err)
+	}
+	config.Renew = false
+	results = LE.MonitorEndpoints(config)
+	if results[0].Matches || !results[0].Drifted() || !results[0].StoredNotAfter.Equal(renewed.NotAfter) {
+		t.Error("Error: the drift wasn't detected ", results[0])
+	}
+	last := notifier.events[len(notifier.events)-1]
+	if last.Type != notify.EventDrift || last.Endpoint != address || last.Domain != "www.example.com" {
+		t.Error("Error: the drift wasn't notified ", last)
+	}
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var defaultExpiryThresholdDays = []int{30, 14, 7, 3, 1}
+
+const defaultRepeatInterval = 24 * time.Hour
+
+// Filters the events before giving them to the Notifier, so a run every few minutes doesn't
+// page for the same problem each time:
+//   - an expiring certificate is reported once per threshold crossed,
+//   - a failure or a drifting endpoint is reported again only after RepeatInterval,
+//   - a success is only reported when it follows a reported failure.
+//
+// The notifications sent are kept in StatePath, when set, to survive between runs.
+type Deduplicator struct {
+	Notifier            Notifier
+	ExpiryThresholdDays []int
+	RepeatInterval      time.Duration
+	StatePath           string
+
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+func NewDeduplicator(notifier Notifier, expiryThresholdDays []int, repeatInterval time.Duration, statePath string) (*Deduplicator, error) {
+	if len(expiryThresholdDays) == 0 {
+		expiryThresholdDays = defaultExpiryThresholdDays
+	}
+	thresholds := append([]int(nil), expiryThresholdDays...)
+	sort.Ints(thresholds)
+	if repeatInterval == 0 {
+		repeatInterval = defaultRepeatInterval
+	}
+	d := &Deduplicator{
+		Notifier:            notifier,
+		ExpiryThresholdDays: thresholds,
+		RepeatInterval:      repeatInterval,
+		StatePath:           statePath,
+		sent:                make(map[string]time.Time),
+	}
+	if err := d.readState(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Deduplicator) Notify(event Event) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.prune(event.Time)
+
+	var key string
+	switch event.Type {
+	case EventExpiring:
+		threshold, ok := d.threshold(daysUntil(event.NotAfter, event.Time))
+		if !ok {
+			return nil
+		}
+		// The expiry date is part of the key, a renewed certificate starts over.
+		key = event.Type + "/" + event.Domain + "/" + strconv.FormatInt(event.NotAfter.Unix(), 10) + "/" + strconv.Itoa(threshold)
+		if _, found := d.sent[key]; found {
+			return nil
+		}
+	case EventObtainFailed:
+		key = event.Type + "/" + event.Domain
+		if last, found := d.sent[key]; found && event.Time.Sub(last) < d.RepeatInterval {
+			return nil
+		}
+	case EventDrift:
+		key = event.Type + "/" + event.Domain + "/" + event.Endpoint
+		if last, found := d.sent[key]; found && event.Time.Sub(last) < d.RepeatInterval {
+			return nil
+		}
+	case EventObtained:
+		failureKey := EventObtainFailed + "/" + event.Domain
+		if _, found := d.sent[failureKey]; !found {
+			return nil
+		}
+		if err := d.Notifier.Notify(event); err != nil {
+			return err
+		}
+		delete(d.sent, failureKey)
+		return d.writeState()
+	default:
+		return d.Notifier.Notify(event)
+	}
+
+	if err := d.Notifier.Notify(event); err != nil {
+		return err
+	}
+	d.sent[key] = event.Time
+	return d.writeState()
+}
+
+// Drop the notifications that no longer hold anything back: the ones sent more than RepeatInterval
+// ago, but the expiries kept until the certificate expires and the failures kept until a success.
+func (d *Deduplicator) prune(now time.Time) {
+	for key, sent := range d.sent {
+		// The key of an expiry is the type, the domain, the expiry date and the threshold.
+		parts := strings.Split(key, "/")
+		switch parts[0] {
+		case EventExpiring:
+			if len(parts) != 4 {
+				continue
+			}
+			if notAfter, err := strconv.ParseInt(parts[2], 10, 64); err == nil && now.After(time.Unix(notAfter, 0)) {
+				delete(d.sent, key)
+			}
+		case EventObtainFailed:
+			// Reported again by the success that ends it.
+		default:
+			if now.Sub(sent) >= d.RepeatInterval {
+				delete(d.sent, key)
+			}
+		}
+	}
+}
+
+// Return the smallest threshold reached by daysLeft.
+func (d *Deduplicator) threshold(daysLeft int) (int, bool) {
+	for _, threshold := range d.ExpiryThresholdDays {
+		if daysLeft <= threshold {
+			return threshold, true
+		}
+	}
+	return 0, false
+}
+
+func (d *Deduplicator) readState() error {
+	if d.StatePath == "" {
+		return nil
+	}
+	stateBytes, err := ioutil.ReadFile(d.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(stateBytes, &d.sent)
+}
+
+func (d *Deduplicator) writeState() error {
+	if d.StatePath == "" {
+		return nil
+	}
+	stateBytes, err := json.Marshal(d.sent)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.StatePath, stateBytes, 0600)
+}
@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The kinds of events sent to the notifiers.
+const (
+	// A certificate couldn't be obtained or renewed.
+	EventObtainFailed = "obtain_failed"
+	// A certificate was obtained or renewed, only forwarded by the Deduplicator after a failure.
+	EventObtained = "obtained"
+	// A stored certificate is about to expire.
+	EventExpiring = "expiring"
+	// An endpoint serves another certificate than the stored one, it wasn't reloaded after a renewal.
+	EventDrift = "drift"
+)
+
+type Event struct {
+	Type   string `json:"type"`
+	Domain string `json:"domain"`
+	// The host:port of a monitored endpoint.
+	Endpoint string    `json:"endpoint,omitempty"`
+	Message  string    `json:"message"`
+	Error    string    `json:"error,omitempty"`
+	NotAfter time.Time `json:"not_after,omitzero"`
+	Time     time.Time `json:"time"`
+}
+
+// Something that tells a human about an event.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type NotifierConfig struct {
+	// Generic JSON webhook, receives the Event as is.
+	WebhookURL string `mapstructure:"webhook_url"`
+	// Slack incoming webhook, or any Slack-compatible one (Mattermost, Rocket.Chat).
+	SlackWebhookURL string     `mapstructure:"slack_webhook_url"`
+	SMTP            SMTPConfig `mapstructure:"smtp"`
+	// Days before expiry at which a certificate is reported, one notification per threshold.
+	ExpiryThresholdDays []int `mapstructure:"expiry_threshold_days"`
+	// Delay before a still failing certificate is reported again.
+	RepeatInterval time.Duration `mapstructure:"repeat_interval"`
+	// File keeping the notifications already sent, so they aren't sent again on the next run.
+	StatePath string `mapstructure:"state_path"`
+}
+
+// Build every configured notifier behind a Deduplicator.
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	var notifiers Multi
+	if config.WebhookURL != "" {
+		notifiers = append(notifiers, NewWebhook(config.WebhookURL))
+	}
+	if config.SlackWebhookURL != "" {
+		notifiers = append(notifiers, NewSlackWebhook(config.SlackWebhookURL))
+	}
+	if config.SMTP.Host != "" {
+		notifiers = append(notifiers, NewSMTP(config.SMTP))
+	}
+	if len(notifiers) == 0 {
+		return nil, errors.New("No notifier configured.")
+	}
+	return NewDeduplicator(notifiers, config.ExpiryThresholdDays, config.RepeatInterval, config.StatePath)
+}
+
+// Sends the events to every notifier, even if one of them fails.
+type Multi []Notifier
+
+func (m Multi) Notify(event Event) error {
+	var messages []string
+	for _, notifier := range m {
+		if err := notifier.Notify(event); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// Human readable version of the event, used as the text of the messages.
+func (e Event) Text() string {
+	switch e.Type {
+	case EventObtainFailed:
+		return fmt.Sprintf("Certificate for %s could not be obtained: %s", e.Domain, e.Error)
+	case EventObtained:
+		return fmt.Sprintf("Certificate for %s obtained again after a failure.", e.Domain)
+	case EventExpiring:
+		return fmt.Sprintf("Certificate for %s expires in %d days (%s).", e.Domain,
+			daysUntil(e.NotAfter, e.Time), e.NotAfter.Format(time.RFC1123))
+	case EventDrift:
+		return fmt.Sprintf("%s serves a certificate for %s expiring on %s, not the stored one.", e.Endpoint,
+			e.Domain, e.NotAfter.Format(time.RFC1123))
+	}
+	return e.Message
+}
+
+func daysUntil(notAfter, now time.Time) int {
+	return int(notAfter.Sub(now).Hours() / 24)
+}
@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Keeps the events it receives.
+type recorder struct {
+	events []Event
+}
+
+func (r *recorder) Notify(event Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestDeduplicatorExpiryThresholds(t *testing.T) {
+	received := &recorder{}
+	dedup, err := NewDeduplicator(received, []int{7, 30}, 0, "")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	now := time.Now()
+	notAfter := now.Add(20 * 24 * time.Hour)
+	for _, daysLeft := range []int{40, 20, 19, 6, 5} {
+		current := notAfter.Add(-time.Duration(daysLeft) * 24 * time.Hour)
+		if err := dedup.Notify(Event{Type: EventExpiring, Domain: "example.com", NotAfter: notAfter, Time: current}); err != nil {
+			t.Error("Error: ", err)
+		}
+	}
+	// One notification when crossing 30 days, one when crossing 7 days.
+	if len(received.events) != 2 {
+		t.Error("Error: expected 2 notifications, got ", len(received.events))
+	}
+}
+
+func TestDeduplicatorFailuresAcrossRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+	now := time.Now()
+	failure := Event{Type: EventObtainFailed, Domain: "example.com", Error: "rate limited", Time: now}
+
+	received := &recorder{}
+	firstRun, _ := NewDeduplicator(received, nil, time.Hour, statePath)
+	_ = firstRun.Notify(failure)
+	// A new run reads what the previous one sent.
+	secondRun, err := NewDeduplicator(received, nil, time.Hour, statePath)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	failure.Time = now.Add(10 * time.Minute)
+	_ = secondRun.Notify(failure)
+	if len(received.events) != 1 {
+		t.Error("Error: the failure was notified again before the repeat interval")
+	}
+	_ = secondRun.Notify(Event{Type: EventObtained, Domain: "example.com", Time: now})
+	_ = secondRun.Notify(Event{Type: EventObtained, Domain: "example.com", Time: now})
+	if len(received.events) != 2 || received.events[1].Type != EventObtained {
+		t.Error("Error: expected a single recovery notification")
+	}
+}
+
+func TestDeduplicatorDriftPerEndpoint(t *testing.T) {
+	received := &recorder{}
+	dedup, err := NewDeduplicator(received, nil, time.Hour, "")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	now := time.Now()
+	for _, event := range []Event{
+		{Type: EventDrift, Domain: "example.com", Endpoint: "10.0.0.1:443", Time: now},
+		{Type: EventDrift, Domain: "example.com", Endpoint: "10.0.0.2:443", Time: now},
+		{Type: EventDrift, Domain: "example.com", Endpoint: "10.0.0.1:443", Time: now.Add(time.Minute)},
+		{Type: EventDrift, Domain: "example.com", Endpoint: "10.0.0.1:443", Time: now.Add(2 * time.Hour)},
+	} {
+		_ = dedup.Notify(event)
+	}
+	if len(received.events) != 3 {
+		t.Error("Error: expected one notification per endpoint and per repeat interval, got ", len(received.events))
+	}
+	if text := received.events[0].Text(); !strings.HasPrefix(text, "10.0.0.1:443 serves a certificate for example.com") {
+		t.Error("Error: wrong text ", text)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	event := Event{Type: EventObtainFailed, Domain: "example.com", Error: "dns timeout", Time: time.Now()}
+	if err := (Multi{NewWebhook(server.URL), NewSlackWebhook(server.URL)}).Notify(event); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatal("Error: expected 2 requests, got ", len(bodies))
+	}
+	if bodies[0]["type"] != EventObtainFailed || bodies[0]["domain"] != "example.com" {
+		t.Error("Error: wrong JSON event ", bodies[0])
+	}
+	if bodies[1]["text"] != "Certificate for example.com could not be obtained: dns timeout" {
+		t.Error("Error: wrong Slack message ", bodies[1])
+	}
+}
+
+func TestSMTPMessage(t *testing.T) {
+	var sentTo []string
+	var sent string
+	mailer := NewSMTP(SMTPConfig{Host: "smtp.example.com", From: "certs@example.com", To: []string{"ops@example.com"}})
+	mailer.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sentTo, sent = to, string(msg)
+		if addr != "smtp.example.com:587" {
+			t.Error("Error: wrong address ", addr)
+		}
+		return nil
+	}
+	if err := mailer.Notify(Event{Type: EventExpiring, Domain: "example.com", NotAfter: time.Now().Add(72 * time.Hour), Time: time.Now()}); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if len(sentTo) != 1 || !strings.Contains(sent, "Subject: [lets-encrypt] expiring: example.com") {
+		t.Error("Error: wrong mail ", sent)
+	}
+}
+
+func TestDeduplicatorPrunesSent(t *testing.T) {
+	received := &recorder{}
+	dedup, err := NewDeduplicator(received, []int{7}, time.Hour, "")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	now := time.Now()
+	for _, event := range []Event{
+		{Type: EventDrift, Domain: "example.com", Endpoint: "10.0.0.1:443", Time: now},
+		{Type: EventExpiring, Domain: "example.com", NotAfter: now.Add(24 * time.Hour), Time: now},
+		{Type: EventObtainFailed, Domain: "example.com", Time: now},
+		{Type: EventDrift, Domain: "example.org", Endpoint: "10.0.0.2:443", Time: now.Add(2 * time.Hour)},
+	} {
+		_ = dedup.Notify(event)
+	}
+	if len(dedup.sent) != 3 {
+		t.Error("Error: expected the old drift to be dropped, got ", dedup.sent)
+	}
+	// The expiry is kept until the certificate expires, the failure until the success.
+	_ = dedup.Notify(Event{Type: EventDrift, Domain: "example.org", Endpoint: "10.0.0.2:443", Time: now.Add(48 * time.Hour)})
+	if len(dedup.sent) != 2 {
+		t.Error("Error: expected the failure and the new drift only, got ", dedup.sent)
+	}
+	_ = dedup.Notify(Event{Type: EventObtained, Domain: "example.com", Time: now.Add(48 * time.Hour)})
+	if len(received.events) != 6 || len(dedup.sent) != 1 {
+		t.Error("Error: the success after the failure wasn't reported ", len(received.events), dedup.sent)
+	}
+}
+
+func TestEventOmitsZeroNotAfter(t *testing.T) {
+	eventJSON, err := json.Marshal(Event{Type: EventObtainFailed, Domain: "example.com"})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if strings.Contains(string(eventJSON), "not_after") {
+		t.Error("Error: the zero expiry was written ", string(eventJSON))
+	}
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+type SMTPConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+// Sends each event by mail.
+type SMTP struct {
+	Config SMTPConfig
+	// Replaced in tests, smtp.SendMail by default.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTP(config SMTPConfig) *SMTP {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	return &SMTP{Config: config, sendMail: smtp.SendMail}
+}
+
+func (s *SMTP) Notify(event Event) error {
+	var auth smtp.Auth
+	if s.Config.Username != "" {
+		auth = smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Config.Host)
+	}
+	addr := net.JoinHostPort(s.Config.Host, strconv.Itoa(s.Config.Port))
+	return s.sendMail(addr, auth, s.Config.From, s.Config.To, s.message(event))
+}
+
+func (s *SMTP) message(event Event) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", s.Config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(s.Config.To, ", "))
+	fmt.Fprintf(&message, "Subject: [lets-encrypt] %s: %s\r\n", event.Type, event.Domain)
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(event.Text() + "\r\n")
+	return []byte(message.String())
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Posts each event to an URL, as the Event JSON or as a Slack message.
+type Webhook struct {
+	URL    string
+	Slack  bool
+	Client *http.Client
+}
+
+// Create a webhook posting the Event as JSON.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Create a webhook posting Slack-compatible `{"text": ...}` messages.
+func NewSlackWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Slack: true, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (w *Webhook) Notify(event Event) error {
+	var payload interface{} = event
+	if w.Slack {
+		payload = map[string]string{"text": event.Text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
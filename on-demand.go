@@ -0,0 +1,183 @@
+package lets_encrypt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+)
+
+// The on-demand issuances started per OnDemandConfig.RateLimitInterval when their settings are zero.
+const (
+	DefaultOnDemandRateLimit         = 10
+	DefaultOnDemandRateLimitInterval = time.Hour
+)
+
+// How long a name whose issuance failed is refused when OnDemandConfig.FailureBackoff is zero.
+const DefaultOnDemandFailureBackoff = 10 * time.Minute
+
+// Issuance of the certificate of a server name on its first handshake, through the DNS provider of the
+// client. At least one of Domains and Decide must be set: the names they don't allow get no certificate.
+type OnDemandConfig struct {
+	// The names that may be issued: a suffix allows the name itself and all its subdomains.
+	Domains []string `mapstructure:"domains"`
+	// Optional, asked for the names Domains allows, an error refuses the name. The context is the one
+	// of the handshake.
+	Decide func(ctx context.Context, serverName string) error `mapstructure:"-"`
+	// Issuances started per RateLimitInterval at most, the names beyond are refused.
+	RateLimit         int           `mapstructure:"rate_limit"`
+	RateLimitInterval time.Duration `mapstructure:"rate_limit_interval"`
+	// How long a name is refused after its issuance failed, so its handshakes don't order again and again.
+	FailureBackoff time.Duration `mapstructure:"failure_backoff"`
+}
+
+// An issuance shared by the handshakes of the same name, err is set once done is closed.
+type onDemandIssuance struct {
+	done chan struct{}
+	err  error
+}
+
+// Check the name against Domains and then Decide.
+func (config *OnDemandConfig) allow(ctx context.Context, serverName string) error {
+	if len(config.Domains) == 0 && config.Decide == nil {
+		return errors.New("The on-demand issuance needs Domains or Decide.")
+	}
+	if len(config.Domains) > 0 {
+		allowed := false
+		for _, suffix := range config.Domains {
+			suffix, err := dns.ToASCII(strings.TrimPrefix(suffix, "*."))
+			if err != nil {
+				return err
+			}
+			allowed = allowed || serverName == suffix || strings.HasSuffix(serverName, "."+suffix)
+		}
+		if !allowed {
+			return errors.New("The on-demand issuance of " + serverName + " isn't allowed.")
+		}
+	}
+	if config.Decide != nil {
+		return config.Decide(ctx, serverName)
+	}
+	return nil
+}
+
+// Issue the certificate of the name, or wait for the issuance already started by another handshake.
+func (c *TLSCertificates) obtainOnDemand(hello *tls.ClientHelloInfo, serverName string) (*tls.Certificate, error) {
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := loggerOrDiscard(c.LE.Logger).With("domain", serverName)
+	issuance, err := c.startOnDemand(ctx, serverName)
+	if err != nil {
+		logger.Info("on-demand issuance refused", "error", err)
+		return nil, err
+	}
+	// Without issuance, the certificate was loaded while the policy was checked.
+	if issuance != nil {
+		select {
+		case <-issuance.done:
+		case <-ctx.Done():
+			// The issuance goes on for the next handshakes.
+			return nil, ctx.Err()
+		}
+		if issuance.err != nil {
+			return nil, issuance.err
+		}
+	}
+	if certificate := c.lookup(serverName); certificate != nil {
+		return certificate, nil
+	}
+	return nil, errors.New("No certificate for " + serverName + ".")
+}
+
+// Return the running issuance of the name, or start one once the policy and the rate limit allow it.
+// The issuance is nil when the certificate was loaded meanwhile.
+func (c *TLSCertificates) startOnDemand(ctx context.Context, serverName string) (*onDemandIssuance, error) {
+	c.onDemandMutex.Lock()
+	issuance, ok := c.issuing[serverName]
+	err := c.backingOff(serverName, time.Now())
+	c.onDemandMutex.Unlock()
+	if ok {
+		return issuance, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Decide may be slow, it isn't asked under the lock.
+	if err := c.OnDemand.allow(ctx, serverName); err != nil {
+		return nil, err
+	}
+
+	c.onDemandMutex.Lock()
+	defer c.onDemandMutex.Unlock()
+	if issuance, ok := c.issuing[serverName]; ok {
+		return issuance, nil
+	}
+	// An issuance that just finished has loaded its certificate before leaving issuing.
+	if c.lookup(serverName) != nil {
+		return nil, nil
+	}
+	limit, interval := c.OnDemand.RateLimit, c.OnDemand.RateLimitInterval
+	if limit <= 0 {
+		limit = DefaultOnDemandRateLimit
+	}
+	if interval <= 0 {
+		interval = DefaultOnDemandRateLimitInterval
+	}
+	now := time.Now()
+	recent := c.started[:0]
+	for _, started := range c.started {
+		if now.Sub(started) < interval {
+			recent = append(recent, started)
+		}
+	}
+	c.started = recent
+	if len(recent) >= limit {
+		return nil, errors.New("More than " + strconv.Itoa(limit) + " on-demand issuances per " + interval.String() + ", " + serverName + " is refused.")
+	}
+	c.started = append(c.started, now)
+	if c.issuing == nil {
+		c.issuing = make(map[string]*onDemandIssuance)
+	}
+	issuance = &onDemandIssuance{done: make(chan struct{})}
+	c.issuing[serverName] = issuance
+	loggerOrDiscard(c.LE.Logger).Info("on-demand issuance", "domain", serverName)
+	go func() {
+		// The certificate is loaded by the watcher of NewTLSCertificates before AskCertificate returns.
+		issuance.err = c.LE.AskCertificate(serverName)
+		c.onDemandMutex.Lock()
+		delete(c.issuing, serverName)
+		if issuance.err != nil {
+			if c.failed == nil {
+				c.failed = make(map[string]time.Time)
+			}
+			c.failed[serverName] = time.Now()
+		}
+		c.onDemandMutex.Unlock()
+		close(issuance.done)
+	}()
+	return issuance, nil
+}
+
+// Refuse the name while its last issuance failed less than FailureBackoff ago, and forget the
+// failures older than that. Called with onDemandMutex held.
+func (c *TLSCertificates) backingOff(serverName string, now time.Time) error {
+	backoff := c.OnDemand.FailureBackoff
+	if backoff <= 0 {
+		backoff = DefaultOnDemandFailureBackoff
+	}
+	for name, failed := range c.failed {
+		if now.Sub(failed) >= backoff {
+			delete(c.failed, name)
+		}
+	}
+	if failed, ok := c.failed[serverName]; ok {
+		return errors.New("The on-demand issuance of " + serverName + " failed, it is refused until " + failed.Add(backoff).Format(time.RFC3339) + ".")
+	}
+	return nil
+}
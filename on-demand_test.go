@@ -0,0 +1,110 @@
+package lets_encrypt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func TestOnDemand(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	certificates, err := NewTLSCertificates(LE)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer certificates.Close()
+
+	certificates.OnDemand = &OnDemandConfig{}
+	if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"}); err == nil {
+		t.Error("Error: a certificate was issued without policy")
+	}
+	certificates.OnDemand = &OnDemandConfig{
+		Domains: []string{"example.com"},
+		Decide: func(ctx context.Context, serverName string) error {
+			if serverName == "blocked.example.com" {
+				return errors.New("blocked")
+			}
+			return nil
+		},
+		RateLimit: 2,
+	}
+
+	// The concurrent handshakes of a name share its issuance.
+	served := make([]*tls.Certificate, 5)
+	var wg sync.WaitGroup
+	for i := range served {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			served[i], _ = certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"})
+		}(i)
+	}
+	wg.Wait()
+	for _, certificate := range served {
+		if certificate == nil || certificate != served[0] {
+			t.Fatal("Error: the handshakes didn't share the certificate ", served)
+		}
+	}
+	if len(certificates.started) != 1 {
+		t.Error("Error: expected one issuance, got ", len(certificates.started))
+	}
+
+	for _, name := range []string{"www.example.org", "evilexample.com", "blocked.example.com", "*.example.com",
+		"a*.example.com", "www..example.com", "-www.example.com", "www_1.example.com", "127.0.0.1"} {
+		if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Error("Error: a certificate was issued for ", name)
+		}
+	}
+	if len(certificates.started) != 1 {
+		t.Error("Error: a refused name was issued")
+	}
+
+	if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.example.com"}); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: "mail.example.com"}); err == nil {
+		t.Error("Error: the rate limit wasn't applied")
+	}
+	// The issued names are still served beyond the rate limit.
+	if serverName(t, certificates, "www.example.com") != served[0] {
+		t.Error("Error: another certificate was served")
+	}
+}
+
+// A name whose issuance failed is refused until its backoff is over.
+func TestOnDemandFailureBackoff(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	roots := LE.Validation.Roots
+	LE.Validation.Roots = x509.NewCertPool()
+	certificates, err := NewTLSCertificates(LE)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer certificates.Close()
+	certificates.OnDemand = &OnDemandConfig{Domains: []string{"example.com"}, FailureBackoff: time.Hour}
+
+	hello := &tls.ClientHelloInfo{ServerName: "www.example.com"}
+	if _, err := certificates.GetCertificate(hello); err == nil {
+		t.Fatal("Error: the issuance didn't fail")
+	}
+	LE.Validation.Roots = roots
+	if _, err := certificates.GetCertificate(hello); err == nil || len(certificates.started) != 1 {
+		t.Error("Error: the name was issued again during its backoff ", err)
+	}
+
+	certificates.failed["www.example.com"] = time.Now().Add(-time.Hour)
+	if _, err := certificates.GetCertificate(hello); err != nil {
+		t.Error("Error: ", err)
+	}
+	if len(certificates.failed) != 0 {
+		t.Error("Error: the failure wasn't forgotten ", certificates.failed)
+	}
+}
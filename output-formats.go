@@ -0,0 +1,118 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// The output formats written next to the .crt and .key files.
+const (
+	// <domain>.pem: the certificate, its chain and the private key in one file, as HAProxy wants it.
+	OutputFormatPEM = "pem"
+	// <domain>.p12: a PKCS#12 keystore protected by Password.
+	OutputFormatPKCS12 = "pkcs12"
+	// <domain>.jks: a Java keystore protected by Password, the key entry is named Alias.
+	OutputFormatJKS = "jks"
+)
+
+type OutputFormatConfig struct {
+	Type     string `mapstructure:"type"`
+	Password string `mapstructure:"password"`
+	// JKS only, the alias of the key entry, the domain name by default.
+	Alias string `mapstructure:"alias"`
+}
+
+// Return the file of every output format asked for the certificate by name, they are all generated
+// again on each renewal.
+func outputFormatFiles(fullDomainName string, certificates *certificate.Resource, formats []OutputFormatConfig) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if len(formats) == 0 {
+		return files, nil
+	}
+	privateKey, err := certcrypto.ParsePEMPrivateKey(certificates.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := certcrypto.ParsePEMBundle(certificates.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, format := range formats {
+		var content []byte
+		var extension string
+		switch format.Type {
+		case OutputFormatPEM:
+			extension = ".pem"
+			content = append(append([]byte{}, certificates.Certificate...), certificates.PrivateKey...)
+		case OutputFormatPKCS12:
+			extension = ".p12"
+			content, err = pkcs12.Modern.Encode(privateKey, chain[0], chain[1:], format.Password)
+		case OutputFormatJKS:
+			extension = ".jks"
+			content, err = encodeJKS(privateKey, chain, format, fullDomainName)
+		default:
+			return nil, errors.New("Unknown output format: " + format.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[fullDomainName+extension] = content
+	}
+	return files, nil
+}
+
+// With the key encryption, no output format may hold the private key in plaintext: the combined PEM
+// isn't written and the keystores need a password.
+func checkOutputFormats(formats []OutputFormatConfig, keyEncryption KeyEncryptionConfig) error {
+	if !keyEncryption.enabled() {
+		return nil
+	}
+	for _, format := range formats {
+		if format.Type == OutputFormatPEM {
+			return errors.New("The combined PEM holds the private key in plaintext, it can't be written with the key encryption.")
+		}
+		if format.Password == "" {
+			return errors.New("The " + format.Type + " keystore needs a password with the key encryption.")
+		}
+	}
+	return nil
+}
+
+func encodeJKS(privateKey interface{}, chain []*x509.Certificate, format OutputFormatConfig, fullDomainName string) ([]byte, error) {
+	if format.Password == "" {
+		return nil, errors.New("A JKS keystore needs a password.")
+	}
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	entry := keystore.PrivateKeyEntry{
+		CreationTime: time.Now(),
+		PrivateKey:   pkcs8Key,
+	}
+	for _, cert := range chain {
+		entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+	}
+	alias := format.Alias
+	if alias == "" {
+		alias = fullDomainName
+	}
+
+	keyStore := keystore.New()
+	if err := keyStore.SetPrivateKeyEntry(alias, entry, []byte(format.Password)); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	if err := keyStore.Store(&buffer, []byte(format.Password)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
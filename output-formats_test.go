@@ -0,0 +1,104 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
+)
+
+func TestWriteOutputFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificates")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer os.RemoveAll(dir)
+	resource := newTestResource(t, "example.com", time.Now().Add(90*24*time.Hour))
+
+	formats := []OutputFormatConfig{
+		{Type: OutputFormatPEM},
+		{Type: OutputFormatPKCS12, Password: "p12-password"},
+		{Type: OutputFormatJKS, Password: "jks-password", Alias: "tomcat"},
+	}
+	files, err := outputFormatFiles("example.com", resource, formats)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := storage.WriteFiles(file.NewFileStorage(dir), "example.com", files); err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	combined, err := ioutil.ReadFile(filepath.Join(dir, "example.com", "example.com.pem"))
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if !bytes.Contains(combined, resource.Certificate) || !bytes.Contains(combined, resource.PrivateKey) {
+		t.Error("Error: the combined PEM misses the certificate or the key")
+	}
+
+	p12, err := ioutil.ReadFile(filepath.Join(dir, "example.com", "example.com.p12"))
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, cert, err := pkcs12.Decode(p12, "p12-password"); err != nil || cert.Subject.CommonName != "example.com" {
+		t.Error("Error: couldn't read back the PKCS#12 keystore ", err)
+	}
+
+	jks, err := os.Open(filepath.Join(dir, "example.com", "example.com.jks"))
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer jks.Close()
+	keyStore := keystore.New()
+	if err := keyStore.Load(jks, []byte("jks-password")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := keyStore.GetPrivateKeyEntry("tomcat", []byte("jks-password")); err != nil {
+		t.Error("Error: couldn't read back the JKS key entry ", err)
+	}
+}
+
+func TestWriteOutputFormatsErrors(t *testing.T) {
+	resource := newTestResource(t, "example.com", time.Now().Add(90*24*time.Hour))
+	if _, err := outputFormatFiles("example.com", resource, []OutputFormatConfig{{Type: "der"}}); err == nil {
+		t.Error("Error: an unknown format should fail")
+	}
+	if _, err := outputFormatFiles("example.com", resource, []OutputFormatConfig{{Type: OutputFormatJKS}}); err == nil {
+		t.Error("Error: a JKS keystore without password should fail")
+	}
+}
+
+func TestOutputFormatsWithKeyEncryption(t *testing.T) {
+	keyEncryption := KeyEncryptionConfig{Passphrase: "correct horse"}
+	for _, format := range []OutputFormatConfig{{Type: OutputFormatPEM}, {Type: OutputFormatPKCS12}, {Type: OutputFormatJKS}} {
+		if err := checkOutputFormats([]OutputFormatConfig{format}, keyEncryption); err == nil {
+			t.Error("Error: the key was written in plaintext in ", format.Type)
+		}
+	}
+	if err := checkOutputFormats([]OutputFormatConfig{{Type: OutputFormatPKCS12, Password: "changeit"}}, keyEncryption); err != nil {
+		t.Error("Error: ", err)
+	}
+
+	// Nothing is ordered nor written.
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.KeyEncryption = keyEncryption
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{
+		OutputFormats: []OutputFormatConfig{{Type: OutputFormatPEM}},
+	}}}
+	if err := LE.AskCertificate("www.example.com"); err == nil {
+		t.Error("Error: the combined PEM was written with the key encryption")
+	}
+	if _, err := os.Stat(filepath.Join(LE.CertificatesRootPath, "www.example.com")); !os.IsNotExist(err) {
+		t.Error("Error: the certificate was written ", err)
+	}
+}
@@ -0,0 +1,255 @@
+package lets_encrypt
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// A certificate is renewed once it expires within this duration, when its profile sets none.
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// Settings of a certificate. Declared once as a named profile in LetsEncrypt.Profiles,
+// they are shared by every certificate referencing it.
+type CertificateProfile struct {
+	// Lego key type of the certificate: "P256", "P384", "2048", "4096" or "8192", CertificateKeyType by default.
+	KeyType string `mapstructure:"key_type"`
+	// Common name of the chain root to prefer when the CA offers several chains.
+	PreferredChain string               `mapstructure:"preferred_chain"`
+	OutputFormats  []OutputFormatConfig `mapstructure:"output_formats"`
+	// Keep the private key of the stored certificate on renewal, for partners pinning our public key.
+	// Nil takes the setting of the profile, false overrides it.
+	ReuseKey *bool `mapstructure:"reuse_key"`
+	// With ReuseKey, a new key is still generated once the stored one is older than this, 0 means never.
+	KeyMaxAge time.Duration `mapstructure:"key_max_age"`
+	// Shell commands run after each issuance, with LE_DOMAIN and LE_CERTIFICATE_DIR in their environment.
+	Hooks []string `mapstructure:"hooks"`
+	// Optional, the ACME server of the client by default. The account key is registered on it when needed.
+	ACMEServer ACMEServerConfig `mapstructure:"acme_server"`
+	// Renewal window, DefaultRenewBefore when zero.
+	RenewBefore time.Duration `mapstructure:"renew_before"`
+	// Optional, the Secret the certificate is also written to.
+	KubernetesSecret KubernetesSecretConfig `mapstructure:"kubernetes_secret"`
+	// Keep the private key in LetsEncrypt.KeyStore, labeled with the domain, instead of a .key file.
+	// Nil takes the setting of the profile, false overrides it.
+	UseKeyStore *bool `mapstructure:"use_key_store"`
+}
+
+// Return a pointer to the value, for ReuseKey and UseKeyStore.
+func Bool(value bool) *bool {
+	return &value
+}
+
+func (p CertificateProfile) reusesKey() bool {
+	return p.ReuseKey != nil && *p.ReuseKey
+}
+
+func (p CertificateProfile) usesKeyStore() bool {
+	return p.UseKeyStore != nil && *p.UseKeyStore
+}
+
+// Return the profile with the unset settings taken from base.
+func (p CertificateProfile) withDefaults(base CertificateProfile) CertificateProfile {
+	if p.KeyType == "" {
+		p.KeyType = base.KeyType
+	}
+	if p.PreferredChain == "" {
+		p.PreferredChain = base.PreferredChain
+	}
+	if p.OutputFormats == nil {
+		p.OutputFormats = base.OutputFormats
+	}
+	if p.ReuseKey == nil {
+		p.ReuseKey = base.ReuseKey
+	}
+	if p.UseKeyStore == nil {
+		p.UseKeyStore = base.UseKeyStore
+	}
+	if p.KeyMaxAge == 0 {
+		p.KeyMaxAge = base.KeyMaxAge
+	}
+	if p.Hooks == nil {
+		p.Hooks = base.Hooks
+	}
+	if p.ACMEServer.CADirURL == "" {
+		p.ACMEServer.CADirURL = base.ACMEServer.CADirURL
+	}
+	if p.ACMEServer.HTTPClient == nil {
+		p.ACMEServer.HTTPClient = base.ACMEServer.HTTPClient
+	}
+	if p.RenewBefore == 0 {
+		p.RenewBefore = base.RenewBefore
+	}
+	if p.KubernetesSecret.Namespace == "" {
+		p.KubernetesSecret = base.KubernetesSecret
+	}
+	return p
+}
+
+// Return the settings of the certificate merged with its profile, or empty settings if it has none.
+// The domains are compared in their ASCII form, which is the Domain of the returned settings.
+func (LE *LetsEncrypt) certificateConfig(fullDomainName string) (CertificateConfig, error) {
+	fullDomainName, err := dns.ToASCII(fullDomainName)
+	if err != nil {
+		return CertificateConfig{}, err
+	}
+	config := CertificateConfig{Domain: fullDomainName}
+	for _, certificateConfig := range LE.Certificates {
+		if domain, _ := dns.ToASCII(certificateConfig.Domain); domain == fullDomainName {
+			config = certificateConfig
+			config.Domain = fullDomainName
+			break
+		}
+	}
+	if config.Profile != "" {
+		profile, ok := LE.Profiles[config.Profile]
+		if !ok {
+			return config, errors.New("Unknown certificate profile: " + config.Profile)
+		}
+		config.CertificateProfile = config.CertificateProfile.withDefaults(profile)
+	}
+	if config.RenewBefore == 0 {
+		config.RenewBefore = DefaultRenewBefore
+	}
+	return config, nil
+}
+
+// Tell whether the certificate is missing or expires within its renewal window.
+func (LE *LetsEncrypt) NeedsRenewal(fullDomainName string) (bool, error) {
+	config, err := LE.certificateConfig(fullDomainName)
+	if err != nil {
+		return false, err
+	}
+	fullDomainName = config.Domain
+	// Don't read the certificate while another process writes it.
+	unlock, _, err := LE.lockCertificate(fullDomainName, false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	cert, err := readStoredCertificate(LE.certificateStorage(), fullDomainName)
+	if storage.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Until(cert.NotAfter) <= config.RenewBefore, nil
+}
+
+// Ask a certificate for every domain of Certificates missing one or within its renewal window.
+// Every domain is tried, the errors are returned together. A certificate renewed by another node
+// while waiting for its lock is skipped.
+func (LE *LetsEncrypt) RenewCertificates() error {
+	var messages []string
+	for _, config := range LE.Certificates {
+		renew, err := LE.NeedsRenewal(config.Domain)
+		if err == nil && renew {
+			err = LE.obtainCertificate(config.Domain, true)
+		}
+		if err != nil {
+			messages = append(messages, config.Domain+": "+err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+// Run the hooks of the certificate, stop at the first failing one or once the lock is lost. LE_CERTIFICATE_DIR is only set
+// for a storage on the local file system.
+func (LE *LetsEncrypt) runHooks(store storage.Storage, config CertificateConfig, lost <-chan struct{}) error {
+	env := append(os.Environ(), "LE_DOMAIN="+config.Domain)
+	if localStorage, ok := store.(storage.LocalStorage); ok {
+		env = append(env, "LE_CERTIFICATE_DIR="+localStorage.LocalPath(config.Domain, ""))
+	}
+	for _, hook := range config.Hooks {
+		if err := checkLease(lost); err != nil {
+			return err
+		}
+		command := exec.Command("sh", "-c", hook)
+		command.Env = env
+		output, err := command.CombinedOutput()
+		if err != nil {
+			loggerOrDiscard(LE.Logger).Error("hook failed", "domain", config.Domain, "hook", hook,
+				"output", string(output), "error", err)
+			return errors.New("Hook '" + hook + "' failed: " + err.Error())
+		}
+		loggerOrDiscard(LE.Logger).Debug("hook run", "domain", config.Domain, "hook", hook)
+	}
+	return nil
+}
+
+// Clients of the other ACME servers, each registered with the key of the user.
+type clientCache struct {
+	mutex    sync.Mutex
+	byDirURL map[string]*lego.Client
+}
+
+// Return the client of the ACME server, the one of LetsEncrypt when no other server is asked.
+func (LE *LetsEncrypt) clientFor(server ACMEServerConfig) (*lego.Client, error) {
+	if server.CADirURL == "" || server.CADirURL == LE.ACMEServer.CADirURL {
+		return LE.Client, nil
+	}
+	if LE.clients == nil {
+		return nil, errors.New("Another ACME server needs a LetsEncrypt created by InitLetsEncrypt.")
+	}
+	LE.clients.mutex.Lock()
+	defer LE.clients.mutex.Unlock()
+	if client, ok := LE.clients.byDirURL[server.CADirURL]; ok {
+		return client, nil
+	}
+	client, err := LE.newRegisteredClient(server)
+	if err != nil {
+		return nil, err
+	}
+	LE.clients.byDirURL[server.CADirURL] = client
+	return client, nil
+}
+
+// The account on another ACME server, registered with the key of the user.
+type serverUser struct {
+	registration.User
+	registration *registration.Resource
+}
+
+func (u *serverUser) GetRegistration() *registration.Resource {
+	return u.registration
+}
+
+// Create a client for another ACME server, registering the key of the user there, and give it
+// the DNS provider.
+func (LE *LetsEncrypt) newRegisteredClient(server ACMEServerConfig) (*lego.Client, error) {
+	if LE.dnsProvider == nil {
+		return nil, errors.New("No DNS provider set, call SetDNSProvider first.")
+	}
+	user := &serverUser{User: LE.User}
+	client, err := lego.NewClient(server.legoConfig(user))
+	if err != nil {
+		return nil, err
+	}
+	// Registering an existing key only returns its account.
+	user.registration, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, err
+	}
+	// The client doesn't know the account it registered, a new one is needed to sign with it.
+	client, err = lego.NewClient(server.legoConfig(user))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Challenge.SetDNS01Provider(LE.dnsProvider, LE.dnsOptions...); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
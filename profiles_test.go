@@ -0,0 +1,98 @@
+package lets_encrypt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func TestCertificateConfigProfiles(t *testing.T) {
+	LE := LetsEncrypt{
+		Profiles: map[string]CertificateProfile{
+			"java": {
+				KeyType:       "4096",
+				OutputFormats: []OutputFormatConfig{{Type: OutputFormatJKS, Password: "changeit"}},
+				RenewBefore:   14 * 24 * time.Hour,
+				ReuseKey:      Bool(true),
+				UseKeyStore:   Bool(true),
+			},
+		},
+		Certificates: []CertificateConfig{
+			{Domain: "app.example.com", Profile: "java", CertificateProfile: CertificateProfile{KeyType: "P384"}},
+			{Domain: "api.example.com", Profile: "java", CertificateProfile: CertificateProfile{ReuseKey: Bool(false), UseKeyStore: Bool(false)}},
+			{Domain: "typo.example.com", Profile: "jav"},
+		},
+	}
+	config, err := LE.certificateConfig("app.example.com")
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if config.KeyType != "P384" {
+		t.Error("Error: the certificate settings must override its profile")
+	}
+	if len(config.OutputFormats) != 1 || config.RenewBefore != 14*24*time.Hour || !config.reusesKey() || !config.usesKeyStore() {
+		t.Error("Error: the profile settings weren't applied")
+	}
+	if config, _ := LE.certificateConfig("api.example.com"); config.reusesKey() || config.usesKeyStore() {
+		t.Error("Error: false on the certificate must override its profile")
+	}
+	if config, _ := LE.certificateConfig("other.example.com"); config.RenewBefore != DefaultRenewBefore {
+		t.Error("Error: wrong default renewal window ", config.RenewBefore)
+	}
+	if _, err := LE.certificateConfig("typo.example.com"); err == nil {
+		t.Error("Error: an unknown profile must fail")
+	}
+}
+
+func TestRenewCertificatesWithProfile(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	// The profile asks for another CA, the account key gets registered there.
+	otherCA, err := acmetest.NewServer(dnsServer)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer otherCA.Close()
+	LE.Validation.Roots.AddCert(otherCA.Root())
+	hookOutput := filepath.Join(t.TempDir(), "hook")
+	LE.Profiles = map[string]CertificateProfile{
+		"internal": {
+			KeyType:    string(certcrypto.EC384),
+			Hooks:      []string{"echo $LE_DOMAIN > " + hookOutput},
+			ACMEServer: ACMEServerConfig{CADirURL: otherCA.DirectoryURL(), HTTPClient: otherCA.HTTPClient()},
+		},
+	}
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", Profile: "internal"}}
+
+	if renew, err := LE.NeedsRenewal("www.example.com"); err != nil || !renew {
+		t.Error("Error: a missing certificate needs a renewal")
+	}
+	if err := LE.RenewCertificates(); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	nameFolder := filepath.Join(LE.CertificatesRootPath, "www.example.com")
+	cert, err := readCertificateFile(filepath.Join(nameFolder, "www.example.com.crt"))
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := cert.Verify(x509VerifyOptions(t, nameFolder, otherCA)); err != nil {
+		t.Error("Error: the certificate wasn't issued by the CA of the profile ", err)
+	}
+	if key, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || key.Curve != elliptic.P384() {
+		t.Error("Error: the key type of the profile wasn't used")
+	}
+	if output, _ := ioutil.ReadFile(hookOutput); strings.TrimSpace(string(output)) != "www.example.com" {
+		t.Error("Error: the hook didn't run ", string(output))
+	}
+	if renew, err := LE.NeedsRenewal("www.example.com"); err != nil || renew {
+		t.Error("Error: a fresh certificate doesn't need a renewal")
+	}
+}
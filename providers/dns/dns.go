@@ -1,6 +1,9 @@
 package dns
 
 import (
+	"log/slog"
+	"time"
+
 	"github.com/go-acme/lego/challenge/dns01"
 )
 
@@ -15,8 +18,18 @@ type DNSServer interface {
 	CleanTXTRecord(domain, name string) error
 }
 
+// Implemented by the DNS servers able to log their operations, the logger is given by the
+// Let's Encrypt client when the provider is set.
+type LoggingDNSServer interface {
+	SetLogger(logger *slog.Logger)
+}
+
 type DNSProvider struct {
 	DNSServer DNSServer
+	// Optional, how long and how often lego checks the TXT record propagation.
+	// Lego's defaults are used when they are zero.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
 }
 
 type DNSServerConfig struct {
@@ -27,11 +40,33 @@ type DNSServerConfig struct {
 	ServerID string `mapstructure:"server_id"`
 }
 
+// Implements slog.LogValuer so the API key never ends in the logs.
+func (c DNSServerConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", c.Name),
+		slog.String("type", c.Type),
+		slog.String("url", c.URL),
+		slog.String("server_id", c.ServerID),
+	)
+}
+
 // Fill the struct DNSProvider with the dnsServer object.
 func NewDNSProvider(dnsServer DNSServer) DNSProvider {
 	return DNSProvider{DNSServer: dnsServer}
 }
 
+// Implements lego's challenge.ProviderTimeout.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = d.PropagationTimeout, d.PollingInterval
+	if timeout == 0 {
+		timeout = dns01.DefaultPropagationTimeout
+	}
+	if interval == 0 {
+		interval = dns01.DefaultPollingInterval
+	}
+	return timeout, interval
+}
+
 // Grab TXT name and value from let's encrypt DNS server using keyAuth and add entry to our DNS server.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 
@@ -1,6 +1,8 @@
 package gandi
 
 import (
+	"log/slog"
+
 	"github.com/prasmussen/gandi-api/client"
 	"github.com/prasmussen/gandi-api/domain/zone"
 
@@ -10,6 +12,7 @@ import (
 type InfoGandi struct {
 	Config dns.DNSServerConfig
 	Client *client.Client
+	Logger *slog.Logger
 }
 
 func InitGandi(config dns.DNSServerConfig) (*InfoGandi, error) {
@@ -28,6 +31,18 @@ func InitDNSServer(config dns.DNSServerConfig) (dns.DNSServer, error) {
 	return DNSServer, nil
 }
 
+// Implements dns.LoggingDNSServer.
+func (gandi *InfoGandi) SetLogger(logger *slog.Logger) {
+	gandi.Logger = logger
+}
+
+func (gandi *InfoGandi) logger() *slog.Logger {
+	if gandi.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return gandi.Logger
+}
+
 func (gandi *InfoGandi) IsAuthoritativeForDomain(domain string) bool {
 	return true
 }
@@ -38,10 +53,12 @@ func (gandi *InfoGandi) getZoneForDomain(domain string) *zone.Zone {
 }
 
 func (gandi *InfoGandi) AddTXTRecord(domain, name, value string) error {
+	gandi.logger().Warn("Gandi TXT records are not implemented, nothing added", "domain", domain, "record", name)
 	return nil
 }
 
 func (gandi *InfoGandi) CleanTXTRecord(domain, name string) error {
+	gandi.logger().Warn("Gandi TXT records are not implemented, nothing removed", "domain", domain, "record", name)
 	return nil
 }
 
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// IDNA2008 without the transitional mapping: "ß" stays "ß", as registries and browsers expect.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.VerifyDNSLength(true),
+	idna.Transitional(false),
+)
+
+// Convert a domain to its ASCII form, the A-labels of ACME and of the DNS, after the IDNA2008
+// checks. An ASCII domain is only lowercased, a wildcard label is kept.
+func ToASCII(domain string) (string, error) {
+	wildcard := strings.HasPrefix(domain, "*.")
+	domain = strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", errors.New("Invalid domain name " + domain + ": " + err.Error())
+	}
+	if wildcard {
+		ascii = "*." + ascii
+	}
+	return ascii, nil
+}
+
+// Convert a domain to its Unicode form, for display. The domain is returned as is when it
+// isn't a valid IDN.
+func ToUnicode(domain string) string {
+	wildcard := strings.HasPrefix(domain, "*.")
+	unicode, err := idnaProfile.ToUnicode(strings.TrimPrefix(domain, "*."))
+	if err != nil {
+		return domain
+	}
+	if wildcard {
+		unicode = "*." + unicode
+	}
+	return unicode
+}
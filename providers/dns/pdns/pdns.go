@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/mittwald/go-powerdns"
 	"github.com/mittwald/go-powerdns/apis/zones"
+	"log/slog"
 	"strings"
 
 	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
@@ -13,6 +14,7 @@ import (
 type InfoPDNS struct {
 	Config dns.DNSServerConfig
 	Client pdns.Client
+	Logger *slog.Logger
 }
 
 func InitDNSServer(config dns.DNSServerConfig) (dns.DNSServer, error) {
@@ -40,8 +42,30 @@ func InitPDNS(DNSServer dns.DNSServerConfig) (*InfoPDNS, error) {
 	}, nil
 }
 
+// Implements dns.LoggingDNSServer.
+func (infopdns *InfoPDNS) SetLogger(logger *slog.Logger) {
+	infopdns.Logger = logger
+}
+
+func (infopdns *InfoPDNS) logger() *slog.Logger {
+	if infopdns.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return infopdns.Logger
+}
+
+// Compare the ASCII forms, PowerDNS lists the zones with their A-labels and a final dot.
 func doesZoneCoversDomain(domain string, zone zones.Zone) bool {
-	return strings.Contains(domain, strings.TrimSuffix(zone.Name, "."))
+	asciiDomain, err := dns.ToASCII(domain)
+	if err != nil {
+		return false
+	}
+	asciiZone, err := dns.ToASCII(zone.Name)
+	if err != nil {
+		return false
+	}
+	asciiDomain = strings.TrimPrefix(asciiDomain, "*.")
+	return asciiDomain == asciiZone || strings.HasSuffix(asciiDomain, "."+asciiZone)
 }
 
 // Lists known zones for a given serverID and return ture of false if a zone is found.
@@ -72,9 +96,11 @@ func (infopdns *InfoPDNS) getZoneForDomain(domain string) (*zones.Zone, error) {
 	}
 	for _, zone := range zonesDomain {
 		if doesZoneCoversDomain(domain, zone) {
+			infopdns.logger().Debug("found zone for domain", "domain", domain, "zone", zone.Name, "zone_id", zone.ID)
 			return &zone, nil
 		}
 	}
+	infopdns.logger().Warn("no zone covers the domain", "domain", domain, "server_id", infopdns.Config.ServerID)
 	return nil, errors.New("Didn't found the zone ")
 }
 
@@ -105,6 +131,7 @@ func (infopdns *InfoPDNS) AddTXTRecord(domain, name, value string) error {
 	); err != nil {
 		return err
 	}
+	infopdns.logger().Debug("TXT record set added", "domain", domain, "zone", zone.Name, "record", name)
 
 	return nil
 }
@@ -122,6 +149,7 @@ func (infopdns *InfoPDNS) CleanTXTRecord(domain, name string) error {
 		"TXT"); err != nil {
 		return err
 	}
+	infopdns.logger().Debug("TXT record set removed", "domain", domain, "zone", zone.Name, "record", name)
 	return nil
 }
 
@@ -90,3 +90,26 @@ func createClient(mockedClientObj *pdns_mocks.Client) InfoPDNS {
 		Client: mockedClientObj,
 	}
 }
+
+func TestDoesZoneCoversDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		zone   string
+		covers bool
+	}{
+		{"blah.pangolin.re", "pangolin.re.", true},
+		{"pangolin.re", "pangolin.re.", true},
+		{"*.pangolin.re", "pangolin.re.", true},
+		{"blah.PANGOLIN.re", "pangolin.re.", true},
+		{"blah.notpangolin.re", "pangolin.re.", false},
+		{"pangolin.re.example.com", "pangolin.re.", false},
+		{"www.bücher.example", "xn--bcher-kva.example.", true},
+		{"www.xn--bcher-kva.example", "bücher.example.", true},
+		{"www.bucher.example", "xn--bcher-kva.example.", false},
+	}
+	for _, test := range tests {
+		if covers := doesZoneCoversDomain(test.domain, zones.Zone{Name: test.zone}); covers != test.covers {
+			t.Error("Error: ", test.domain, " in ", test.zone, ": expected ", test.covers)
+		}
+	}
+}
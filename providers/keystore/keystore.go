@@ -0,0 +1,45 @@
+package keystore
+
+import (
+	"crypto"
+	"errors"
+)
+
+// The types of key store.
+const KeyStoreTypePKCS11 = "pkcs11"
+
+// Keeps the private keys of the certificates, the keys never leave it: the certificates are
+// requested with a CSR signed by the key, and only a reference to the key is stored.
+type KeyStore interface {
+	// Return the key of the label, generated with the lego key type ("P256", "P384", "2048",
+	// "4096" or "8192") when the store has none. created tells a generated key.
+	Signer(label string, keyType string) (signer crypto.Signer, created bool, err error)
+	// Return the key of the label, ErrKeyNotFound when the store has none. Nothing is generated.
+	FindSigner(label string) (crypto.Signer, error)
+	// Where the key of the label is kept, written in the metadata instead of the .key file.
+	Reference(label string) KeyReference
+	Close() error
+}
+
+// Returned by FindSigner for a label without key.
+var ErrKeyNotFound = errors.New("The key was not found in the key store.")
+
+// Identifies a key of a key store.
+type KeyReference struct {
+	Type  string `json:"type"`
+	Token string `json:"token,omitempty"`
+	Label string `json:"label"`
+}
+
+type KeyStoreConfig struct {
+	Type   string       `mapstructure:"type"`
+	PKCS11 PKCS11Config `mapstructure:"pkcs11"`
+}
+
+type PKCS11Config struct {
+	// Path of the PKCS#11 module of the HSM, as /usr/lib/softhsm/libsofthsm2.so.
+	Module     string `mapstructure:"module"`
+	TokenLabel string `mapstructure:"token_label"`
+	// PIN of the user of the token.
+	PIN string `mapstructure:"pin"`
+}
@@ -0,0 +1,89 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+)
+
+// Keys of a token of a PKCS#11 module, found and generated by label. The label is also the
+// CKA_ID of the generated keys.
+type PKCS11KeyStore struct {
+	Config keystore.KeyStoreConfig
+
+	context *crypto11.Context
+	// Keep two concurrent orders from generating the same key twice.
+	mutex sync.Mutex
+}
+
+func InitKeyStore(config keystore.KeyStoreConfig) (keystore.KeyStore, error) {
+	return NewPKCS11KeyStore(config)
+}
+
+// Open a session with the token of the config, logged in with its PIN.
+func NewPKCS11KeyStore(config keystore.KeyStoreConfig) (*PKCS11KeyStore, error) {
+	if config.PKCS11.Module == "" || config.PKCS11.TokenLabel == "" {
+		return nil, errors.New("The PKCS#11 module and token label are required.")
+	}
+	context, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.PKCS11.Module,
+		TokenLabel: config.PKCS11.TokenLabel,
+		Pin:        config.PKCS11.PIN,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PKCS11KeyStore{Config: config, context: context}, nil
+}
+
+func (s *PKCS11KeyStore) Signer(label string, keyType string) (crypto.Signer, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	signer, err := s.context.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return nil, false, err
+	}
+	if signer != nil {
+		return signer, false, nil
+	}
+	switch keyType {
+	case "P256":
+		signer, err = s.context.GenerateECDSAKeyPairWithLabel([]byte(label), []byte(label), elliptic.P256())
+	case "P384":
+		signer, err = s.context.GenerateECDSAKeyPairWithLabel([]byte(label), []byte(label), elliptic.P384())
+	case "2048", "4096", "8192":
+		bits, _ := strconv.Atoi(keyType)
+		signer, err = s.context.GenerateRSAKeyPairWithLabel([]byte(label), []byte(label), bits)
+	default:
+		return nil, false, errors.New("Unsupported key type: " + keyType)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return signer, true, nil
+}
+
+func (s *PKCS11KeyStore) FindSigner(label string) (crypto.Signer, error) {
+	signer, err := s.context.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, keystore.ErrKeyNotFound
+	}
+	return signer, nil
+}
+
+func (s *PKCS11KeyStore) Reference(label string) keystore.KeyReference {
+	return keystore.KeyReference{Type: keystore.KeyStoreTypePKCS11, Token: s.Config.PKCS11.TokenLabel, Label: label}
+}
+
+func (s *PKCS11KeyStore) Close() error {
+	return s.context.Close()
+}
@@ -0,0 +1,106 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/keystore"
+)
+
+// Where the distributions install the SoftHSMv2 module, SOFTHSM2_MODULE overrides them.
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/lib64/pkcs11/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// Create a token in a temporary SoftHSMv2 directory, the test is skipped without SoftHSMv2.
+func newTestKeyStore(t *testing.T) *PKCS11KeyStore {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, path := range softHSMModules {
+		if _, err := os.Stat(path); module == "" && err == nil {
+			module = path
+		}
+	}
+	if _, err := exec.LookPath("softhsm2-util"); module == "" || err != nil {
+		t.Skip("SoftHSMv2 is not installed")
+	}
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := ioutil.WriteFile(conf, []byte("directories.tokendir = "+dir+"\n"), 0600); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+	output, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "lets-encrypt",
+		"--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatal("Error: ", err, string(output))
+	}
+	store, err := NewPKCS11KeyStore(keystore.KeyStoreConfig{
+		Type:   keystore.KeyStoreTypePKCS11,
+		PKCS11: keystore.PKCS11Config{Module: module, TokenLabel: "lets-encrypt", PIN: "1234"},
+	})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestPKCS11KeyStore(t *testing.T) {
+	store := newTestKeyStore(t)
+	for _, test := range []struct {
+		label   string
+		keyType string
+	}{{"www.example.com", "P256"}, {"api.example.com", "2048"}} {
+		signer, created, err := store.Signer(test.label, test.keyType)
+		if err != nil || !created {
+			t.Fatal("Error: the key wasn't generated ", err)
+		}
+		digest := sha256.Sum256([]byte("message"))
+		signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatal("Error: ", err)
+		}
+		switch publicKey := signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			if test.keyType != "P256" || !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+				t.Error("Error: invalid ECDSA signature")
+			}
+		case *rsa.PublicKey:
+			if test.keyType != "2048" || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+				t.Error("Error: invalid RSA signature")
+			}
+		}
+
+		found, created, err := store.Signer(test.label, test.keyType)
+		if err != nil || created {
+			t.Fatal("Error: the key wasn't found ", err)
+		}
+		if !found.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+			t.Error("Error: another key was found")
+		}
+	}
+	if found, err := store.FindSigner("www.example.com"); err != nil || found == nil {
+		t.Error("Error: the key wasn't found ", err)
+	}
+	if _, err := store.FindSigner("other.example.com"); err != keystore.ErrKeyNotFound {
+		t.Error("Error: expected no key ", err)
+	}
+	if _, _, err := store.Signer("other.example.com", "ed25519"); err == nil {
+		t.Error("Error: an unsupported key type was accepted")
+	}
+	if reference := store.Reference("www.example.com"); reference.Token != "lets-encrypt" || reference.Label != "www.example.com" {
+		t.Error("Error: wrong reference ", reference)
+	}
+}
@@ -0,0 +1,131 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+)
+
+// Consul refuses the sessions with a shorter TTL.
+const minTTL = 10 * time.Second
+
+// Locks in the Consul KV store: a lock is a key acquired by a session of the TTL. The key is
+// deleted with the session when the session isn't renewed.
+type ConsulLocker struct {
+	Config lock.LockConfig
+
+	client *http.Client
+}
+
+func InitLocker(config lock.LockConfig) (lock.Locker, error) {
+	return NewConsulLocker(config)
+}
+
+func NewConsulLocker(config lock.LockConfig) (*ConsulLocker, error) {
+	if config.Consul.URL == "" {
+		return nil, errors.New("The Consul URL is missing.")
+	}
+	if config.Prefix == "" {
+		config.Prefix = lock.DefaultPrefix
+	}
+	client, err := lock.NewHTTPClient(config.Consul.CACert)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulLocker{Config: config, client: client}, nil
+}
+
+type consulLease struct {
+	locker  *ConsulLocker
+	session string
+}
+
+func (c *ConsulLocker) TryLock(key string, ttl time.Duration) (lock.Lease, bool, error) {
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	session := map[string]string{
+		"Name":     "lets-encrypt " + key,
+		"TTL":      strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10) + "s",
+		"Behavior": "delete",
+		// The key can be acquired again as soon as it is free.
+		"LockDelay": "0s",
+	}
+	var created struct {
+		ID string `json:"ID"`
+	}
+	if err := c.request(http.MethodPut, "/v1/session/create", nil, session, &created); err != nil {
+		return nil, false, err
+	}
+	lease := &consulLease{locker: c, session: created.ID}
+	var acquired bool
+	query := url.Values{"acquire": {created.ID}}
+	if err := c.request(http.MethodPut, "/v1/kv/"+c.Config.Prefix+key, query, lock.NewOwner(), &acquired); err != nil || !acquired {
+		lease.Unlock()
+		return nil, false, err
+	}
+	return lease, true, nil
+}
+
+func (l *consulLease) Refresh() error {
+	err := l.locker.request(http.MethodPut, "/v1/session/renew/"+l.session, nil, nil, nil)
+	if errors.Is(err, errNotFound) {
+		return lock.ErrLockLost
+	}
+	return err
+}
+
+// Destroy the session, deleting the key.
+func (l *consulLease) Unlock() error {
+	return l.locker.request(http.MethodPut, "/v1/session/destroy/"+l.session, nil, nil, nil)
+}
+
+var errNotFound = errors.New("Not found in Consul.")
+
+func (c *ConsulLocker) request(method, apiPath string, query url.Values, body interface{}, response interface{}) error {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		if bodyBytes, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	requestURL := strings.TrimSuffix(c.Config.Consul.URL, "/") + apiPath
+	if query != nil {
+		requestURL += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(method, requestURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return err
+	}
+	if c.Config.Consul.Token != "" {
+		request.Header.Set("X-Consul-Token", c.Config.Consul.Token)
+	}
+	httpResponse, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if httpResponse.StatusCode >= 300 {
+		return errors.New("Consul " + method + " " + apiPath + ": " + httpResponse.Status + " " + strings.TrimSpace(string(responseBytes)))
+	}
+	if response != nil {
+		return json.Unmarshal(responseBytes, response)
+	}
+	return nil
+}
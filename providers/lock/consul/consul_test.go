@@ -0,0 +1,111 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock/locktest"
+)
+
+// The sessions and the KV acquire of the Consul API, kept in memory.
+type fakeConsul struct {
+	mutex    sync.Mutex
+	nextID   int
+	sessions map[string]string
+	// The sessions holding the keys.
+	keys map[string]string
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if r.Header.Get("X-Consul-Token") != "token" || r.Method != http.MethodPut {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		var session map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.nextID++
+		id := "session-" + strconv.Itoa(f.nextID)
+		f.sessions[id] = session["TTL"]
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		if _, ok := f.sessions[id]; !ok {
+			http.Error(w, "Session id '"+id+"' not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"ID": id}})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		f.destroy(strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		w.Write([]byte("true"))
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		session := r.URL.Query().Get("acquire")
+		if _, ok := f.sessions[session]; !ok {
+			http.Error(w, "invalid session", http.StatusInternalServerError)
+			return
+		}
+		if holder, held := f.keys[key]; held && holder != session {
+			w.Write([]byte("false"))
+			return
+		}
+		f.keys[key] = session
+		w.Write([]byte("true"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Destroy the session, deleting its keys as the "delete" behavior does.
+func (f *fakeConsul) destroy(id string) {
+	delete(f.sessions, id)
+	for key, session := range f.keys {
+		if session == id {
+			delete(f.keys, key)
+		}
+	}
+}
+
+func (f *fakeConsul) expire() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for id := range f.sessions {
+		f.destroy(id)
+	}
+}
+
+func TestConsulLocker(t *testing.T) {
+	fake := &fakeConsul{sessions: make(map[string]string), keys: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	locker, err := NewConsulLocker(lock.LockConfig{Prefix: "certificates-lock/", Consul: lock.ConsulConfig{URL: server.URL, Token: "token"}})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	locktest.Run(t, locker, fake.expire)
+
+	lease, ok, err := locker.TryLock("leader", 0)
+	if err != nil || !ok {
+		t.Fatal("Error: ", err)
+	}
+	if session, held := fake.keys["certificates-lock/leader"]; !held || fake.sessions[session] != "10s" {
+		t.Error("Error: the key must be held by a session of the minimum TTL ", fake.sessions[session])
+	}
+	lease.Unlock()
+	if len(fake.sessions) != 0 || len(fake.keys) != 0 {
+		t.Error("Error: the session and its keys weren't destroyed")
+	}
+}
@@ -0,0 +1,182 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+)
+
+// Locks in etcd through its JSON gateway: a lock is a key created only if missing, attached to a
+// lease of the TTL. The key is deleted with the lease when it isn't kept alive.
+type EtcdLocker struct {
+	Config lock.LockConfig
+
+	client *http.Client
+	mutex  sync.Mutex
+	token  string
+}
+
+func InitLocker(config lock.LockConfig) (lock.Locker, error) {
+	return NewEtcdLocker(config)
+}
+
+func NewEtcdLocker(config lock.LockConfig) (*EtcdLocker, error) {
+	if len(config.Etcd.Endpoints) == 0 {
+		return nil, errors.New("No etcd endpoint given.")
+	}
+	if config.Prefix == "" {
+		config.Prefix = lock.DefaultPrefix
+	}
+	client, err := lock.NewHTTPClient(config.Etcd.CACert)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdLocker{Config: config, client: client}, nil
+}
+
+type etcdLease struct {
+	locker *EtcdLocker
+	id     int64
+}
+
+func (e *EtcdLocker) TryLock(key string, ttl time.Duration) (lock.Lease, bool, error) {
+	var grant struct {
+		ID int64 `json:"ID,string"`
+	}
+	if err := e.call("/v3/lease/grant", map[string]int64{"TTL": seconds(ttl)}, &grant); err != nil {
+		return nil, false, err
+	}
+	lease := &etcdLease{locker: e, id: grant.ID}
+	encodedKey := base64.StdEncoding.EncodeToString([]byte(e.Config.Prefix + key))
+	txn := map[string]interface{}{
+		// The key is created only if it doesn't exist yet.
+		"compare": []map[string]string{{"key": encodedKey, "target": "CREATE", "result": "EQUAL", "create_revision": "0"}},
+		"success": []map[string]interface{}{{"request_put": map[string]string{
+			"key":   encodedKey,
+			"value": base64.StdEncoding.EncodeToString([]byte(lock.NewOwner())),
+			"lease": strconv.FormatInt(grant.ID, 10),
+		}}},
+	}
+	var response struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call("/v3/kv/txn", txn, &response); err != nil || !response.Succeeded {
+		lease.Unlock()
+		return nil, false, err
+	}
+	return lease, true, nil
+}
+
+func (l *etcdLease) Refresh() error {
+	var response struct {
+		Result struct {
+			TTL int64 `json:"TTL,string"`
+		} `json:"result"`
+	}
+	if err := l.locker.call("/v3/lease/keepalive", map[string]string{"ID": strconv.FormatInt(l.id, 10)}, &response); err != nil {
+		return err
+	}
+	// An expired lease is kept alive for 0 seconds.
+	if response.Result.TTL <= 0 {
+		return lock.ErrLockLost
+	}
+	return nil
+}
+
+// Revoke the lease, deleting the key.
+func (l *etcdLease) Unlock() error {
+	return l.locker.call("/v3/lease/revoke", map[string]string{"ID": strconv.FormatInt(l.id, 10)}, nil)
+}
+
+// The TTL of the leases in seconds, rounded up.
+func seconds(ttl time.Duration) int64 {
+	s := int64((ttl + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+// Call the API on the first endpoint answering, authenticating again once when the token is refused.
+func (e *EtcdLocker) call(apiPath string, body interface{}, response interface{}) error {
+	token, err := e.authToken(false)
+	if err != nil {
+		return err
+	}
+	status, err := e.post(apiPath, token, body, response)
+	if status == http.StatusUnauthorized && e.Config.Etcd.Username != "" {
+		if token, err = e.authToken(true); err != nil {
+			return err
+		}
+		_, err = e.post(apiPath, token, body, response)
+	}
+	return err
+}
+
+// Return the token, authenticating when there is none yet or when renew is set.
+func (e *EtcdLocker) authToken(renew bool) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.Config.Etcd.Username == "" || (e.token != "" && !renew) {
+		return e.token, nil
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	credentials := map[string]string{"name": e.Config.Etcd.Username, "password": e.Config.Etcd.Password}
+	if _, err := e.post("/v3/auth/authenticate", "", credentials, &response); err != nil {
+		return "", err
+	}
+	e.token = response.Token
+	return e.token, nil
+}
+
+func (e *EtcdLocker) post(apiPath, token string, body interface{}, response interface{}) (int, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	var lastErr error
+	for _, endpoint := range e.Config.Etcd.Endpoints {
+		request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+apiPath, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set("Authorization", token)
+		}
+		httpResponse, err := e.client.Do(request)
+		if err != nil {
+			// The member is down, try the next one.
+			lastErr = err
+			continue
+		}
+		responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+		httpResponse.Body.Close()
+		if err != nil {
+			return httpResponse.StatusCode, err
+		}
+		if httpResponse.StatusCode >= 300 {
+			var etcdError struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(responseBytes, &etcdError)
+			return httpResponse.StatusCode, errors.New("etcd " + apiPath + ": " + httpResponse.Status + " " + etcdError.Message)
+		}
+		if response != nil {
+			return httpResponse.StatusCode, json.Unmarshal(responseBytes, response)
+		}
+		return httpResponse.StatusCode, nil
+	}
+	return 0, lastErr
+}
@@ -0,0 +1,136 @@
+package etcd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock/locktest"
+)
+
+// The part of the etcd JSON gateway used by the locks, with the leases kept in memory.
+type fakeEtcd struct {
+	mutex  sync.Mutex
+	token  string
+	nextID int64
+	leases map[int64]bool
+	// The leases holding the keys.
+	keys map[string]int64
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var body struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+		ID       int64  `json:"ID,string"`
+		TTL      int64  `json:"TTL"`
+		Compare  []struct {
+			Key string `json:"key"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut struct {
+				Key   string `json:"key"`
+				Lease int64  `json:"lease,string"`
+			} `json:"request_put"`
+		} `json:"success"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/v3/auth/authenticate" {
+		if body.Name != "root" || body.Password != "secret" {
+			http.Error(w, `{"message":"authentication failed"}`, http.StatusBadRequest)
+			return
+		}
+		f.token = "token-" + strconv.FormatInt(f.nextID, 10)
+		f.nextID++
+		json.NewEncoder(w).Encode(map[string]string{"token": f.token})
+		return
+	}
+	if r.Header.Get("Authorization") != f.token {
+		http.Error(w, `{"message":"invalid auth token"}`, http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		f.nextID++
+		f.leases[f.nextID] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": strconv.FormatInt(f.nextID, 10), "TTL": strconv.FormatInt(body.TTL, 10)})
+	case "/v3/kv/txn":
+		key := body.Compare[0].Key
+		if _, exists := f.keys[key]; exists {
+			json.NewEncoder(w).Encode(map[string]bool{})
+			return
+		}
+		f.keys[body.Success[0].RequestPut.Key] = body.Success[0].RequestPut.Lease
+		json.NewEncoder(w).Encode(map[string]bool{"succeeded": true})
+	case "/v3/lease/keepalive":
+		result := map[string]string{"ID": strconv.FormatInt(body.ID, 10)}
+		if f.leases[body.ID] {
+			result["TTL"] = "60"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	case "/v3/lease/revoke":
+		f.revoke(body.ID)
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeEtcd) revoke(id int64) {
+	delete(f.leases, id)
+	for key, lease := range f.keys {
+		if lease == id {
+			delete(f.keys, key)
+		}
+	}
+}
+
+func (f *fakeEtcd) expire() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for id := range f.leases {
+		f.revoke(id)
+	}
+}
+
+func TestEtcdLocker(t *testing.T) {
+	fake := &fakeEtcd{leases: make(map[int64]bool), keys: make(map[string]int64)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// The first member is down.
+	locker, err := NewEtcdLocker(lock.LockConfig{Etcd: lock.EtcdConfig{
+		Endpoints: []string{down.URL, server.URL}, Username: "root", Password: "secret",
+	}})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	locktest.Run(t, locker, fake.expire)
+
+	// The token expired, the locker authenticates again.
+	fake.token = "revoked"
+	lease, ok, err := locker.TryLock("leader", 0)
+	if err != nil || !ok {
+		t.Fatal("Error: the lock wasn't taken with a new token ", err)
+	}
+	key := base64.StdEncoding.EncodeToString([]byte("lets-encrypt/leader"))
+	if _, exists := fake.keys[key]; !exists {
+		t.Error("Error: the key isn't under the default prefix")
+	}
+	lease.Unlock()
+	if _, exists := fake.keys[key]; exists {
+		t.Error("Error: the key wasn't deleted with its lease")
+	}
+}
@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// The types of distributed lock.
+const LockTypeEtcd = "etcd"
+const LockTypeConsul = "consul"
+const LockTypeDatabase = "database"
+
+// Prefix of the keys of the locks when the config sets none.
+const DefaultPrefix = "lets-encrypt/"
+
+// A lock shared by the nodes renewing the same certificates. A lock is held for a TTL and must be
+// refreshed before it expires, so the lock of a crashed node is free again once its TTL is over.
+type Locker interface {
+	// Take the lock once, without waiting. ok is false when another node holds it.
+	TryLock(key string, ttl time.Duration) (lease Lease, ok bool, err error)
+}
+
+// A lock held by this node.
+type Lease interface {
+	// Hold the lock for another TTL, fails once the lock was lost.
+	Refresh() error
+	Unlock() error
+}
+
+// Returned by Refresh when the lock expired or was taken by another node.
+var ErrLockLost = errors.New("The lock was lost.")
+
+type LockConfig struct {
+	Type string `mapstructure:"type"`
+	// Prefix of the keys, DefaultPrefix by default.
+	Prefix string `mapstructure:"prefix"`
+	// How long a lock is held without refresh, DefaultLockTTL of the lets_encrypt package by default.
+	TTL time.Duration `mapstructure:"ttl"`
+	// Only the elected leader runs the scheduler.
+	LeaderElection bool                   `mapstructure:"leader_election"`
+	Etcd           EtcdConfig             `mapstructure:"etcd"`
+	Consul         ConsulConfig           `mapstructure:"consul"`
+	Database       storage.DatabaseConfig `mapstructure:"database"`
+}
+
+type EtcdConfig struct {
+	// URLs of the members, tried in order, as "https://etcd-1.example.com:2379".
+	Endpoints []string `mapstructure:"endpoints"`
+	Username  string   `mapstructure:"username"`
+	Password  string   `mapstructure:"password"`
+	// Optional, a PEM file of the CA to trust for the members.
+	CACert string `mapstructure:"ca_cert"`
+}
+
+type ConsulConfig struct {
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+	// Optional, a PEM file of the CA to trust for the Consul agent.
+	CACert string `mapstructure:"ca_cert"`
+}
+
+// Return a value naming this node and this lock, stored with the lock to tell its holder.
+func NewOwner() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	return hostname + "/" + hex.EncodeToString(random)
+}
+
+// Return an HTTP client trusting the CA of the PEM file, the system roots when it is empty.
+func NewHTTPClient(caCert string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caCert == "" {
+		return client, nil
+	}
+	caBytes, err := ioutil.ReadFile(caCert)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBytes) {
+		return nil, errors.New("No certificate found in " + caCert + ".")
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	return client, nil
+}
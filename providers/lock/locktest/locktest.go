@@ -0,0 +1,52 @@
+// Package locktest checks the behavior shared by the implementations of lock.Locker.
+package locktest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+)
+
+// Run the checks on the locker. expire makes every lock held reach the end of its TTL.
+func Run(t *testing.T, locker lock.Locker, expire func()) {
+	ttl := time.Minute
+	first, ok, err := locker.TryLock("certificates/example.com", ttl)
+	if err != nil || !ok {
+		t.Fatal("Error: the free lock wasn't taken ", err)
+	}
+	if _, ok, err := locker.TryLock("certificates/example.com", ttl); err != nil || ok {
+		t.Fatal("Error: the lock was taken twice ", err)
+	}
+	other, ok, err := locker.TryLock("certificates/www.example.com", ttl)
+	if err != nil || !ok {
+		t.Fatal("Error: the lock of another key wasn't taken ", err)
+	}
+	if err := first.Refresh(); err != nil {
+		t.Error("Error: ", err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Error("Error: ", err)
+	}
+	second, ok, err := locker.TryLock("certificates/example.com", ttl)
+	if err != nil || !ok {
+		t.Fatal("Error: the released lock wasn't taken ", err)
+	}
+	if err := other.Unlock(); err != nil {
+		t.Error("Error: ", err)
+	}
+
+	// The lock of a node that stopped refreshing it goes to another one.
+	expire()
+	third, ok, err := locker.TryLock("certificates/example.com", ttl)
+	if err != nil || !ok {
+		t.Fatal("Error: the expired lock wasn't taken ", err)
+	}
+	if err := second.Refresh(); !errors.Is(err, lock.ErrLockLost) {
+		t.Error("Error: the expired lock was refreshed ", err)
+	}
+	if err := third.Unlock(); err != nil {
+		t.Error("Error: ", err)
+	}
+}
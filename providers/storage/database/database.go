@@ -0,0 +1,343 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// The supported databases.
+const DriverSQLite = "sqlite"
+const DriverPostgres = "postgres"
+
+// Created on start when missing. The certificate and its key are kept in certificate_versions, one
+// row per issuance, the certificates row points to the current one and holds what the queries need.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS accounts (
+		path TEXT NOT NULL,
+		name TEXT NOT NULL,
+		content {{blob}} NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (path, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS certificates (
+		domain TEXT PRIMARY KEY,
+		current_version INTEGER NOT NULL,
+		dns_names TEXT NOT NULL,
+		serial_number TEXT NOT NULL,
+		issuer TEXT NOT NULL,
+		not_before TIMESTAMP NOT NULL,
+		not_after TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS certificates_not_after ON certificates (not_after)`,
+	`CREATE TABLE IF NOT EXISTS certificate_versions (
+		domain TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		certificate TEXT NOT NULL,
+		private_key {{blob}} NOT NULL,
+		not_after TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (domain, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS certificate_metadata (
+		domain TEXT PRIMARY KEY,
+		content TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS certificate_files (
+		domain TEXT NOT NULL,
+		name TEXT NOT NULL,
+		content {{blob}} NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (domain, name)
+	)`,
+	// The distributed locks, see TryLock. The expiry is in Unix milliseconds, to compare it the same
+	// way in every database.
+	`CREATE TABLE IF NOT EXISTS locks (
+		name TEXT PRIMARY KEY,
+		owner TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`,
+}
+
+// The files in SQL tables: the account files in accounts, under the storage path, and for each
+// certificate, <domain>.crt and <domain>.key in certificate_versions, <domain>.json in
+// certificate_metadata and the other files in certificate_files. The versions whose key is kept in a
+// key store have an empty private_key.
+type DatabaseStorage struct {
+	Config storage.StorageConfig
+	DB     *sql.DB
+}
+
+func InitStorage(config storage.StorageConfig) (storage.Storage, error) {
+	return NewDatabaseStorage(config)
+}
+
+// Connect to the database and create the missing tables.
+func NewDatabaseStorage(config storage.StorageConfig) (*DatabaseStorage, error) {
+	driverName := config.Database.Driver
+	switch config.Database.Driver {
+	case DriverSQLite:
+	case DriverPostgres:
+		driverName = "pgx"
+	default:
+		return nil, errors.New("Unknown database driver: " + config.Database.Driver)
+	}
+	if config.Path == "" {
+		config.Path = "default"
+	}
+	db, err := sql.Open(driverName, config.Database.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if config.Database.Driver == DriverSQLite {
+		// SQLite allows one writer, the connections would wait on each other's locks.
+		db.SetMaxOpenConns(1)
+	}
+	d := &DatabaseStorage{Config: config, DB: db}
+	blob := "BLOB"
+	if config.Database.Driver == DriverPostgres {
+		blob = "BYTEA"
+	}
+	for _, statement := range schema {
+		if _, err := db.Exec(strings.ReplaceAll(statement, "{{blob}}", blob)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (d *DatabaseStorage) Close() error {
+	return d.DB.Close()
+}
+
+// Write the query with "?" placeholders, numbered for PostgreSQL.
+func (d *DatabaseStorage) query(query string) string {
+	if d.Config.Database.Driver != DriverPostgres {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, char := range query {
+		if char == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
+
+// Timestamps are stored in UTC to the second, so they compare the same way in every database.
+func timestamp(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func (d *DatabaseStorage) ReadFile(dir, name string) ([]byte, error) {
+	var content []byte
+	var err error
+	switch {
+	case dir == "":
+		err = d.DB.QueryRow(d.query(`SELECT content FROM accounts WHERE path = ? AND name = ?`),
+			d.Config.Path, name).Scan(&content)
+	case name == dir+".crt":
+		err = d.DB.QueryRow(d.query(`SELECT v.certificate FROM certificates c
+			JOIN certificate_versions v ON v.domain = c.domain AND v.version = c.current_version
+			WHERE c.domain = ?`), dir).Scan(&content)
+	case name == dir+".key":
+		err = d.DB.QueryRow(d.query(`SELECT v.private_key FROM certificates c
+			JOIN certificate_versions v ON v.domain = c.domain AND v.version = c.current_version
+			WHERE c.domain = ?`), dir).Scan(&content)
+		if err == nil && len(content) == 0 {
+			return nil, storage.NotExist(dir, name)
+		}
+	case name == dir+".json":
+		err = d.DB.QueryRow(d.query(`SELECT content FROM certificate_metadata WHERE domain = ?`), dir).Scan(&content)
+	default:
+		err = d.DB.QueryRow(d.query(`SELECT content FROM certificate_files WHERE domain = ? AND name = ?`),
+			dir, name).Scan(&content)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.NotExist(dir, name)
+	}
+	return content, err
+}
+
+func (d *DatabaseStorage) WriteFile(dir, name string, content []byte) error {
+	return d.WriteFiles(dir, map[string][]byte{name: content})
+}
+
+// Implements storage.TransactionalStorage. A new certificate or key makes a new version, the
+// missing one of the two is taken from the current version.
+func (d *DatabaseStorage) WriteFiles(dir string, files map[string][]byte) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := d.writeFiles(tx, dir, files); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DatabaseStorage) writeFiles(tx *sql.Tx, dir string, files map[string][]byte) error {
+	now := timestamp(time.Now())
+	if dir == "" {
+		for name, content := range files {
+			if _, err := tx.Exec(d.query(`INSERT INTO accounts (path, name, content, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (path, name) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at`),
+				d.Config.Path, name, content, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	certificatePEM, hasCertificate := files[dir+".crt"]
+	privateKey, hasKey := files[dir+".key"]
+	if hasCertificate || hasKey {
+		keyStore, err := hasKeyReference(files[dir+".json"])
+		if err != nil {
+			return err
+		}
+		if err := d.writeVersion(tx, dir, certificatePEM, privateKey, keyStore, now); err != nil {
+			return err
+		}
+	}
+	for name, content := range files {
+		var err error
+		switch name {
+		case dir + ".crt", dir + ".key":
+			continue
+		case dir + ".json":
+			_, err = tx.Exec(d.query(`INSERT INTO certificate_metadata (domain, content, updated_at) VALUES (?, ?, ?)
+				ON CONFLICT (domain) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at`),
+				dir, string(content), now)
+		default:
+			_, err = tx.Exec(d.query(`INSERT INTO certificate_files (domain, name, content, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (domain, name) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at`),
+				dir, name, content, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tell whether the metadata written with the certificate refers to a key of a key store.
+func hasKeyReference(metadata []byte) (bool, error) {
+	if metadata == nil {
+		return false, nil
+	}
+	var content struct {
+		KeyReference json.RawMessage `json:"key_reference"`
+	}
+	if err := json.Unmarshal(metadata, &content); err != nil {
+		return false, err
+	}
+	return len(content.KeyReference) > 0 && string(content.KeyReference) != "null", nil
+}
+
+// Add a version of the certificate of the domain and make it the current one. A version whose key
+// is in a key store gets an empty key, the key of the current version is never carried over to it.
+func (d *DatabaseStorage) writeVersion(tx *sql.Tx, domain string, certificatePEM, privateKey []byte, keyStore bool, now time.Time) error {
+	var currentCertificate string
+	var currentKey []byte
+	var version int
+	err := tx.QueryRow(d.query(`SELECT v.certificate, v.private_key, v.version FROM certificates c
+		JOIN certificate_versions v ON v.domain = c.domain AND v.version = c.current_version
+		WHERE c.domain = ?`), domain).Scan(&currentCertificate, &currentKey, &version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if certificatePEM == nil {
+		certificatePEM = []byte(currentCertificate)
+	}
+	switch {
+	case keyStore:
+		privateKey = []byte{}
+	case privateKey == nil:
+		privateKey = currentKey
+	}
+	if len(certificatePEM) == 0 || (!keyStore && len(privateKey) == 0) {
+		return errors.New("The certificate and the key of " + domain + " must be written together the first time.")
+	}
+	cert, err := certcrypto.ParsePEMCertificate(certificatePEM)
+	if err != nil {
+		return err
+	}
+	// The last version may not be the current one if it was rolled back by hand.
+	if err := tx.QueryRow(d.query(`SELECT COALESCE(MAX(version), 0) FROM certificate_versions WHERE domain = ?`),
+		domain).Scan(&version); err != nil {
+		return err
+	}
+	version++
+	notBefore, notAfter := timestamp(cert.NotBefore), timestamp(cert.NotAfter)
+	if _, err := tx.Exec(d.query(`INSERT INTO certificate_versions
+		(domain, version, certificate, private_key, not_after, created_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		domain, version, string(certificatePEM), privateKey, notAfter, now); err != nil {
+		return err
+	}
+	_, err = tx.Exec(d.query(`INSERT INTO certificates
+		(domain, current_version, dns_names, serial_number, issuer, not_before, not_after, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (domain) DO UPDATE SET current_version = excluded.current_version,
+			dns_names = excluded.dns_names, serial_number = excluded.serial_number, issuer = excluded.issuer,
+			not_before = excluded.not_before, not_after = excluded.not_after, updated_at = excluded.updated_at`),
+		domain, version, strings.Join(cert.DNSNames, ","), cert.SerialNumber.Text(16), cert.Issuer.CommonName,
+		notBefore, notAfter, now)
+	return err
+}
+
+// The domains having a certificate.
+func (d *DatabaseStorage) ListDirs() ([]string, error) {
+	rows, err := d.DB.Query(`SELECT domain FROM certificates ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+// Return the domains whose certificate expires before the given date, only the zone and its
+// subdomains when zone is not empty.
+func (d *DatabaseStorage) ExpiringBefore(before time.Time, zone string) ([]string, error) {
+	rows, err := d.DB.Query(d.query(`SELECT domain FROM certificates
+		WHERE not_after < ? AND (? = '' OR domain = ? OR domain LIKE ?) ORDER BY not_after, domain`),
+		timestamp(before), zone, zone, "%."+zone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
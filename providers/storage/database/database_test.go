@@ -0,0 +1,146 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Set LE_TEST_POSTGRES_DSN to run the tests on PostgreSQL too.
+func testConfigs(t *testing.T) map[string]storage.StorageConfig {
+	configs := map[string]storage.StorageConfig{
+		DriverSQLite: {Database: storage.DatabaseConfig{Driver: DriverSQLite, DSN: filepath.Join(t.TempDir(), "certificates.db")}},
+	}
+	if dsn := os.Getenv("LE_TEST_POSTGRES_DSN"); dsn != "" {
+		configs[DriverPostgres] = storage.StorageConfig{Database: storage.DatabaseConfig{Driver: DriverPostgres, DSN: dsn}}
+	}
+	return configs
+}
+
+func testCertificate(t *testing.T, domain string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDatabaseStorage(t *testing.T) {
+	for driver, config := range testConfigs(t) {
+		databaseStorage, err := NewDatabaseStorage(config)
+		if err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		defer databaseStorage.Close()
+		domain := "www.example.com"
+
+		if _, err := databaseStorage.ReadFile(domain, domain+".crt"); !storage.IsNotExist(err) {
+			t.Error("Error: ", driver, " expected a missing certificate ", err)
+		}
+		// A key alone can't be stored, it belongs to a certificate.
+		if err := databaseStorage.WriteFile(domain, domain+".key", []byte("key")); err == nil {
+			t.Error("Error: ", driver, " a key was stored without its certificate")
+		}
+
+		// All or none: the metadata isn't kept with an invalid certificate.
+		if err := databaseStorage.WriteFiles(domain, map[string][]byte{
+			domain + ".crt":  []byte("not a certificate"),
+			domain + ".key":  []byte("key"),
+			domain + ".json": []byte("{}"),
+		}); err == nil {
+			t.Error("Error: ", driver, " an invalid certificate was stored")
+		}
+		if _, err := databaseStorage.ReadFile(domain, domain+".json"); !storage.IsNotExist(err) {
+			t.Error("Error: ", driver, " the transaction wasn't rolled back ", err)
+		}
+
+		first := testCertificate(t, domain, time.Now().Add(5*24*time.Hour))
+		if err := storage.WriteFiles(databaseStorage, domain, map[string][]byte{
+			domain + ".crt":  first,
+			domain + ".key":  []byte("first key"),
+			domain + ".json": []byte(`{"domain":"www.example.com"}`),
+			domain + ".p12":  {0x30, 0x82, 0xff},
+		}); err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		// Renewal keeping the key: a new version with the key of the current one.
+		second := testCertificate(t, domain, time.Now().Add(90*24*time.Hour))
+		if err := databaseStorage.WriteFile(domain, domain+".crt", second); err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		for name, expected := range map[string]string{
+			domain + ".crt":  string(second),
+			domain + ".key":  "first key",
+			domain + ".json": `{"domain":"www.example.com"}`,
+			domain + ".p12":  string([]byte{0x30, 0x82, 0xff}),
+		} {
+			content, err := databaseStorage.ReadFile(domain, name)
+			if err != nil || string(content) != expected {
+				t.Error("Error: ", driver, " wrong content for ", name, " ", err)
+			}
+		}
+		var versions int
+		if err := databaseStorage.DB.QueryRow(databaseStorage.query(`SELECT COUNT(*) FROM certificate_versions WHERE domain = ?`),
+			domain).Scan(&versions); err != nil || versions != 2 {
+			t.Error("Error: ", driver, " expected 2 versions, got ", versions, " ", err)
+		}
+
+		if err := databaseStorage.WriteFile("", "registration.json", []byte("{}")); err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		if content, err := databaseStorage.ReadFile("", "registration.json"); err != nil || string(content) != "{}" {
+			t.Error("Error: ", driver, " the account file wasn't read back ", err)
+		}
+		if dirs, err := databaseStorage.ListDirs(); err != nil || len(dirs) != 1 || dirs[0] != domain {
+			t.Error("Error: ", driver, " unexpected directories ", dirs, err)
+		}
+	}
+}
+
+func TestExpiringBefore(t *testing.T) {
+	for driver, config := range testConfigs(t) {
+		databaseStorage, err := NewDatabaseStorage(config)
+		if err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		defer databaseStorage.Close()
+		for domain, days := range map[string]int{"a.example.com": 3, "example.com": 5, "b.example.com": 60, "a.example.org": 2} {
+			files := map[string][]byte{
+				domain + ".crt": testCertificate(t, domain, time.Now().Add(time.Duration(days)*24*time.Hour)),
+				domain + ".key": []byte("key"),
+			}
+			if err := databaseStorage.WriteFiles(domain, files); err != nil {
+				t.Fatal("Error: ", driver, " ", err)
+			}
+		}
+		nextWeek := time.Now().Add(7 * 24 * time.Hour)
+		domains, err := databaseStorage.ExpiringBefore(nextWeek, "example.com")
+		if err != nil || len(domains) != 2 || domains[0] != "a.example.com" || domains[1] != "example.com" {
+			t.Error("Error: ", driver, " unexpected domains ", domains, err)
+		}
+		if domains, err := databaseStorage.ExpiringBefore(nextWeek, ""); err != nil || len(domains) != 3 {
+			t.Error("Error: ", driver, " unexpected domains ", domains, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Connect to the database of the config to keep the locks in it.
+func InitLocker(config lock.LockConfig) (lock.Locker, error) {
+	return NewDatabaseStorage(storage.StorageConfig{Type: storage.StorageTypeDatabase, Database: config.Database})
+}
+
+// Implements lock.Locker with a row of the locks table, taken when it is missing or expired.
+func (d *DatabaseStorage) TryLock(key string, ttl time.Duration) (lock.Lease, bool, error) {
+	lease := &databaseLease{storage: d, name: key, owner: lock.NewOwner()}
+	now := time.Now()
+	result, err := d.DB.Exec(d.query(`INSERT INTO locks (name, owner, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE locks.expires_at <= ?`),
+		key, lease.owner, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return nil, false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return nil, false, err
+	}
+	lease.ttl = ttl
+	return lease, true, nil
+}
+
+type databaseLease struct {
+	storage *DatabaseStorage
+	name    string
+	owner   string
+	ttl     time.Duration
+}
+
+func (l *databaseLease) Refresh() error {
+	now := time.Now()
+	result, err := l.storage.DB.Exec(l.storage.query(`UPDATE locks SET expires_at = ?
+		WHERE name = ? AND owner = ? AND expires_at > ?`),
+		now.Add(l.ttl).UnixMilli(), l.name, l.owner, now.UnixMilli())
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return lock.ErrLockLost
+	}
+	return nil
+}
+
+func (l *databaseLease) Unlock() error {
+	_, err := l.storage.DB.Exec(l.storage.query(`DELETE FROM locks WHERE name = ? AND owner = ?`), l.name, l.owner)
+	return err
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock/locktest"
+)
+
+func TestDatabaseLocker(t *testing.T) {
+	for driver, config := range testConfigs(t) {
+		databaseStorage, err := NewDatabaseStorage(config)
+		if err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		defer databaseStorage.Close()
+		if _, err := databaseStorage.DB.Exec(`DELETE FROM locks`); err != nil {
+			t.Fatal("Error: ", driver, " ", err)
+		}
+		locktest.Run(t, databaseStorage, func() {
+			if _, err := databaseStorage.DB.Exec(`UPDATE locks SET expires_at = 0`); err != nil {
+				t.Fatal("Error: ", driver, " ", err)
+			}
+		})
+	}
+}
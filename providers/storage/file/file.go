@@ -0,0 +1,83 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// The files in directories under Root, <root>/<domain>/<domain>.crt for a certificate.
+type FileStorage struct {
+	Root string
+}
+
+func InitStorage(config storage.StorageConfig) (storage.Storage, error) {
+	return NewFileStorage(config.Path), nil
+}
+
+func NewFileStorage(root string) *FileStorage {
+	return &FileStorage{Root: root}
+}
+
+// Implements storage.LocalStorage.
+func (f *FileStorage) LocalPath(dir, name string) string {
+	return filepath.Join(f.Root, dir, name)
+}
+
+func (f *FileStorage) ReadFile(dir, name string) ([]byte, error) {
+	return ioutil.ReadFile(f.LocalPath(dir, name))
+}
+
+// The directory is created when needed. The file is replaced at once, a reader never sees it half written.
+// The certificates and the metadata are readable by everyone, the other files only by the owner,
+// they may hold a private key.
+func (f *FileStorage) WriteFile(dir, name string, content []byte) error {
+	if err := os.MkdirAll(filepath.Join(f.Root, dir), os.ModePerm); err != nil {
+		return err
+	}
+	mode := os.FileMode(0600)
+	if ext := filepath.Ext(name); ext == ".crt" || ext == ".json" {
+		mode = 0644
+	}
+	file, err := ioutil.TempFile(filepath.Join(f.Root, dir), "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), f.LocalPath(dir, name))
+}
+
+// Implements storage.RemovableStorage.
+func (f *FileStorage) RemoveFile(dir, name string) error {
+	if err := os.Remove(f.LocalPath(dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileStorage) ListDirs() ([]string, error) {
+	entries, err := ioutil.ReadDir(f.Root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
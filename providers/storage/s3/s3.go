@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// The files as objects of a bucket, with the layout of the directories under the prefix:
+// <prefix>/<domain>/<domain>.crt for a certificate and <prefix>/<name> for the account.
+type S3Storage struct {
+	Config storage.StorageConfig
+
+	client     *minio.Client
+	encryption encrypt.ServerSide
+}
+
+func InitStorage(config storage.StorageConfig) (storage.Storage, error) {
+	return NewS3Storage(config)
+}
+
+// Create the client and turn the versioning of the bucket on when asked.
+func NewS3Storage(config storage.StorageConfig) (*S3Storage, error) {
+	if config.S3.Endpoint == "" || config.S3.Bucket == "" {
+		return nil, errors.New("The S3 endpoint and bucket are needed.")
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.IAM{},
+	})
+	if config.S3.AccessKey != "" {
+		creds = credentials.NewStaticV4(config.S3.AccessKey, config.S3.SecretKey, "")
+	}
+	client, err := minio.New(config.S3.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !config.S3.Insecure,
+		Region: config.S3.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s3Storage := &S3Storage{Config: config, client: client}
+
+	switch config.S3.ServerSideEncryption {
+	case "":
+	case "AES256":
+		s3Storage.encryption = encrypt.NewSSE()
+	case "aws:kms":
+		if s3Storage.encryption, err = encrypt.NewSSEKMS(config.S3.KMSKeyID, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unknown S3 server-side encryption: " + config.S3.ServerSideEncryption)
+	}
+
+	if config.S3.Versioning {
+		if err := client.EnableVersioning(context.Background(), config.S3.Bucket); err != nil {
+			return nil, err
+		}
+	}
+	return s3Storage, nil
+}
+
+func (s *S3Storage) objectName(dir, name string) string {
+	return strings.TrimPrefix(path.Join(s.Config.Path, dir, name), "/")
+}
+
+func (s *S3Storage) ReadFile(dir, name string) ([]byte, error) {
+	object, err := s.client.GetObject(context.Background(), s.Config.S3.Bucket, s.objectName(dir, name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.convertError(err, dir, name)
+	}
+	defer object.Close()
+	content, err := ioutil.ReadAll(object)
+	if err != nil {
+		return nil, s.convertError(err, dir, name)
+	}
+	return content, nil
+}
+
+// With the versioning of the bucket, the previous content stays as an older version.
+func (s *S3Storage) WriteFile(dir, name string, content []byte) error {
+	_, err := s.client.PutObject(context.Background(), s.Config.S3.Bucket, s.objectName(dir, name),
+		bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+			ContentType:          "application/octet-stream",
+			ServerSideEncryption: s.encryption,
+		})
+	return err
+}
+
+// Implements storage.RemovableStorage. With the versioning of the bucket, the content stays as an older version.
+func (s *S3Storage) RemoveFile(dir, name string) error {
+	return s.client.RemoveObject(context.Background(), s.Config.S3.Bucket, s.objectName(dir, name), minio.RemoveObjectOptions{})
+}
+
+func (s *S3Storage) ListDirs() ([]string, error) {
+	prefix := s.objectName("", "")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var dirs []string
+	for object := range s.client.ListObjects(context.Background(), s.Config.S3.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		// Without the recursive option, the directories come as keys ending with a slash.
+		if strings.HasSuffix(object.Key, "/") {
+			dirs = append(dirs, strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/"))
+		}
+	}
+	return dirs, nil
+}
+
+func (s *S3Storage) convertError(err error, dir, name string) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return storage.NotExist(dir, name)
+	}
+	return err
+}
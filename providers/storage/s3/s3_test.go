@@ -0,0 +1,110 @@
+package s3
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Start an in-memory S3 server with an empty bucket and return the config of a storage using it.
+func newTestS3(t *testing.T) storage.StorageConfig {
+	backend := s3mem.New()
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+	if err := backend.CreateBucket("certificates"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	serverURL, _ := url.Parse(server.URL)
+	return storage.StorageConfig{
+		Type: storage.StorageTypeS3,
+		Path: "letsencrypt",
+		S3: storage.S3Config{
+			Endpoint:  serverURL.Host,
+			Region:    "us-east-1",
+			Bucket:    "certificates",
+			AccessKey: "access",
+			SecretKey: "secret",
+			Insecure:  true,
+		},
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	config := newTestS3(t)
+	config.S3.Versioning = true
+	config.S3.ServerSideEncryption = "AES256"
+	s3Storage, err := NewS3Storage(config)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	if _, err := s3Storage.ReadFile("example.com", "example.com.crt"); !storage.IsNotExist(err) {
+		t.Error("Error: expected a missing file ", err)
+	}
+	for _, content := range []string{"first", "second"} {
+		if err := s3Storage.WriteFile("example.com", "example.com.crt", []byte(content)); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	if err := s3Storage.WriteFile("www.example.com", "www.example.com.crt", []byte("other")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := s3Storage.WriteFile("", "registration.json", []byte("{}")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	content, err := s3Storage.ReadFile("example.com", "example.com.crt")
+	if err != nil || string(content) != "second" {
+		t.Error("Error: the file wasn't read back ", string(content), err)
+	}
+	if _, err := s3Storage.ReadFile("", "registration.json"); err != nil {
+		t.Error("Error: ", err)
+	}
+
+	// The layout mirrors the directories, the previous content is kept as a version.
+	versions := 0
+	for object := range s3Storage.client.ListObjects(context.Background(), "certificates", minio.ListObjectsOptions{
+		Prefix:       "letsencrypt/example.com/example.com.crt",
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			t.Fatal("Error: ", object.Err)
+		}
+		versions++
+	}
+	if versions != 2 {
+		t.Error("Error: expected 2 versions, got ", versions)
+	}
+	dirs, err := s3Storage.ListDirs()
+	if err != nil || len(dirs) != 2 || dirs[0] != "example.com" || dirs[1] != "www.example.com" {
+		t.Error("Error: unexpected directories ", dirs, err)
+	}
+
+	if err := s3Storage.RemoveFile("www.example.com", "www.example.com.crt"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if _, err := s3Storage.ReadFile("www.example.com", "www.example.com.crt"); !storage.IsNotExist(err) {
+		t.Error("Error: the file wasn't removed ", err)
+	}
+	if err := s3Storage.RemoveFile("www.example.com", "www.example.com.key"); err != nil {
+		t.Error("Error: ", err)
+	}
+}
+
+func TestS3StorageConfig(t *testing.T) {
+	config := newTestS3(t)
+	config.S3.ServerSideEncryption = "rot13"
+	if _, err := NewS3Storage(config); err == nil {
+		t.Error("Error: an unknown encryption was accepted")
+	}
+	config.S3.Bucket = ""
+	if _, err := NewS3Storage(config); err == nil {
+		t.Error("Error: a storage without bucket was created")
+	}
+}
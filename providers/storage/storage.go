@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// The types of storage of the certificates and of the account.
+const StorageTypeFile = "file"
+const StorageTypeVault = "vault"
+const StorageTypeS3 = "s3"
+const StorageTypeDatabase = "database"
+
+// Where the certificates or the account are kept. The files are grouped in directories: one per
+// certificate, named after the domain, and the directory "" for the account.
+type Storage interface {
+	// Read a file, the error matches fs.ErrNotExist when it doesn't exist.
+	ReadFile(dir, name string) ([]byte, error)
+	WriteFile(dir, name string, content []byte) error
+	// Names of the directories, the domains of the stored certificates.
+	ListDirs() ([]string, error)
+}
+
+// Implemented by the storages able to write several files of a directory at once: all are
+// written, or none. The key and the certificate then always change together.
+type TransactionalStorage interface {
+	WriteFiles(dir string, files map[string][]byte) error
+}
+
+// Write the files of the directory, at once when the storage supports it, one by one otherwise.
+func WriteFiles(store Storage, dir string, files map[string][]byte) error {
+	if transactional, ok := store.(TransactionalStorage); ok {
+		return transactional.WriteFiles(dir, files)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := store.WriteFile(dir, name, files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Implemented by the storages keeping each file apart, a file written before can be removed. Removing
+// a file that doesn't exist isn't an error.
+type RemovableStorage interface {
+	RemoveFile(dir, name string) error
+}
+
+// Remove the file of the directory. The storages without RemoveFile, as the database, keep the key with
+// the certificate: writing the certificate replaces it.
+func RemoveFile(store Storage, dir, name string) error {
+	if removable, ok := store.(RemovableStorage); ok {
+		return removable.RemoveFile(dir, name)
+	}
+	return nil
+}
+
+// Implemented by the storages keeping the files on the local file system, they can be locked
+// between processes and given to the hooks.
+type LocalStorage interface {
+	LocalPath(dir, name string) string
+}
+
+type StorageConfig struct {
+	Type string `mapstructure:"type"`
+	// Directory of the files, path of the secrets in the Vault mount, prefix of the S3 objects
+	// or name of the account in the database.
+	Path     string         `mapstructure:"path"`
+	Vault    VaultConfig    `mapstructure:"vault"`
+	S3       S3Config       `mapstructure:"s3"`
+	Database DatabaseConfig `mapstructure:"database"`
+}
+
+type VaultConfig struct {
+	URL string `mapstructure:"url"`
+	// Mount of the KV version 2 secrets engine, "secret" by default.
+	Mount     string `mapstructure:"mount"`
+	Namespace string `mapstructure:"namespace"`
+	// Optional, a PEM file of the CA to trust for the Vault server.
+	CACert string `mapstructure:"ca_cert"`
+	// Token auth, or AppRole auth when RoleID is set.
+	Token    string `mapstructure:"token"`
+	RoleID   string `mapstructure:"role_id"`
+	SecretID string `mapstructure:"secret_id"`
+	// Mount of the AppRole auth method, "approle" by default.
+	AppRoleMount string `mapstructure:"approle_mount"`
+}
+
+type S3Config struct {
+	// Host and port of the S3 API, "s3.amazonaws.com" for AWS.
+	Endpoint string `mapstructure:"endpoint"`
+	Region   string `mapstructure:"region"`
+	Bucket   string `mapstructure:"bucket"`
+	// Static credentials, the AWS environment variables and instance role are used without them.
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	// Plain HTTP, for a local MinIO.
+	Insecure bool `mapstructure:"insecure"`
+	// Turn the versioning of the bucket on, it is left as it is otherwise.
+	Versioning bool `mapstructure:"versioning"`
+	// Server-side encryption: "AES256" for keys managed by S3, "aws:kms" with KMSKeyID, none when empty.
+	ServerSideEncryption string `mapstructure:"server_side_encryption"`
+	KMSKeyID             string `mapstructure:"kms_key_id"`
+}
+
+type DatabaseConfig struct {
+	// "sqlite" or "postgres".
+	Driver string `mapstructure:"driver"`
+	// A file name for SQLite, a connection string or URL for PostgreSQL.
+	DSN string `mapstructure:"dsn"`
+}
+
+// Error returned for a file that doesn't exist, it matches fs.ErrNotExist.
+func NotExist(dir, name string) error {
+	return &fs.PathError{Op: "read", Path: dir + "/" + name, Err: fs.ErrNotExist}
+}
+
+// Tell whether the error is about a file that doesn't exist.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,310 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Suffix of the fields holding a binary file, base64 encoded.
+const base64Suffix = "_base64"
+
+// How many times a write is tried again when the secret changed since it was read.
+const casRetries = 3
+
+// How long a request to Vault may take, a hung Vault must not hold the certificate locks forever.
+const requestTimeout = 30 * time.Second
+
+// The files in a KV version 2 mount: one secret per directory, at <path>/<domain> for a certificate
+// and at <path> for the account. A file is a field of the secret named after its extension,
+// "crt", "key" or "json", its whole name otherwise. Binary files are base64 encoded in a field
+// with the "_base64" suffix.
+type VaultStorage struct {
+	Config storage.StorageConfig
+
+	client *http.Client
+	mutex  sync.Mutex
+	token  string
+}
+
+func InitStorage(config storage.StorageConfig) (storage.Storage, error) {
+	return NewVaultStorage(config)
+}
+
+func NewVaultStorage(config storage.StorageConfig) (*VaultStorage, error) {
+	if config.Vault.URL == "" {
+		return nil, errors.New("The Vault URL is missing.")
+	}
+	if config.Path == "" {
+		return nil, errors.New("The path of the secrets in the Vault mount is missing.")
+	}
+	if config.Vault.Token == "" && config.Vault.RoleID == "" {
+		return nil, errors.New("A Vault token or AppRole is needed.")
+	}
+	if config.Vault.Mount == "" {
+		config.Vault.Mount = "secret"
+	}
+	if config.Vault.AppRoleMount == "" {
+		config.Vault.AppRoleMount = "approle"
+	}
+	client := &http.Client{Timeout: requestTimeout}
+	if config.Vault.CACert != "" {
+		caCert, err := ioutil.ReadFile(config.Vault.CACert)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("No certificate found in " + config.Vault.CACert + ".")
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	}
+	return &VaultStorage{Config: config, client: client, token: config.Vault.Token}, nil
+}
+
+func (v *VaultStorage) ReadFile(dir, name string) ([]byte, error) {
+	data, _, err := v.readSecret(dir)
+	if storage.IsNotExist(err) {
+		return nil, storage.NotExist(dir, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	field := fieldName(dir, name)
+	if value, ok := data[field]; ok {
+		return []byte(value), nil
+	}
+	if value, ok := data[field+base64Suffix]; ok {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return nil, storage.NotExist(dir, name)
+}
+
+func (v *VaultStorage) WriteFile(dir, name string, content []byte) error {
+	return v.WriteFiles(dir, map[string][]byte{name: content})
+}
+
+// Implements storage.TransactionalStorage, the files are written in one version of the secret.
+// The other fields of the secret are kept, the write fails if another client changed it meanwhile
+// more than casRetries times.
+func (v *VaultStorage) WriteFiles(dir string, files map[string][]byte) error {
+	for try := 0; ; try++ {
+		data, version, err := v.readSecret(dir)
+		if err != nil && !storage.IsNotExist(err) {
+			return err
+		}
+		if data == nil {
+			data = make(map[string]string)
+		}
+		for name, content := range files {
+			field := fieldName(dir, name)
+			delete(data, field)
+			delete(data, field+base64Suffix)
+			if utf8.Valid(content) {
+				data[field] = string(content)
+			} else {
+				data[field+base64Suffix] = base64.StdEncoding.EncodeToString(content)
+			}
+		}
+		err = v.writeSecret(dir, data, version)
+		if err == nil || !errors.Is(err, errCheckAndSet) || try == casRetries {
+			return err
+		}
+	}
+}
+
+// Implements storage.RemovableStorage, the file is left out of a new version of the secret.
+func (v *VaultStorage) RemoveFile(dir, name string) error {
+	for try := 0; ; try++ {
+		data, version, err := v.readSecret(dir)
+		if storage.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		field := fieldName(dir, name)
+		if _, ok := data[field]; !ok {
+			if _, ok := data[field+base64Suffix]; !ok {
+				return nil
+			}
+		}
+		delete(data, field)
+		delete(data, field+base64Suffix)
+		err = v.writeSecret(dir, data, version)
+		if err == nil || !errors.Is(err, errCheckAndSet) || try == casRetries {
+			return err
+		}
+	}
+}
+
+func (v *VaultStorage) ListDirs() ([]string, error) {
+	var response struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	status, err := v.do("LIST", v.Config.Vault.Mount+"/metadata/"+v.Config.Path, nil, &response)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, key := range response.Data.Keys {
+		// Keys ending with a slash are folders, not secrets.
+		if !strings.HasSuffix(key, "/") {
+			dirs = append(dirs, key)
+		}
+	}
+	return dirs, nil
+}
+
+// The field of the secret holding the file: its extension when the file is named after the directory.
+func fieldName(dir, name string) string {
+	if dir != "" && strings.HasPrefix(name, dir+".") {
+		return strings.TrimPrefix(name, dir+".")
+	}
+	return name
+}
+
+func (v *VaultStorage) secretPath(dir string) string {
+	return path.Join(v.Config.Path, dir)
+}
+
+var errCheckAndSet = errors.New("The Vault secret changed since it was read.")
+
+// Read the fields of the secret and its version, the error matches fs.ErrNotExist when there is none.
+func (v *VaultStorage) readSecret(dir string) (map[string]string, int, error) {
+	var response struct {
+		Data struct {
+			Data     map[string]string `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	status, err := v.do(http.MethodGet, v.Config.Vault.Mount+"/data/"+v.secretPath(dir), nil, &response)
+	if status == http.StatusNotFound {
+		// A deleted secret still has a version, needed to write it again.
+		return nil, response.Data.Metadata.Version, storage.NotExist(dir, "")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return response.Data.Data, response.Data.Metadata.Version, nil
+}
+
+// Write the secret, only if its version is still the one read.
+func (v *VaultStorage) writeSecret(dir string, data map[string]string, version int) error {
+	body := map[string]interface{}{
+		"data":    data,
+		"options": map[string]int{"cas": version},
+	}
+	status, err := v.do(http.MethodPost, v.Config.Vault.Mount+"/data/"+v.secretPath(dir), body, nil)
+	if status == http.StatusBadRequest && err != nil && strings.Contains(err.Error(), "check-and-set") {
+		return errCheckAndSet
+	}
+	return err
+}
+
+// Send a request to the Vault API, logging in again once with the AppRole when the token is refused.
+func (v *VaultStorage) do(method, apiPath string, body interface{}, response interface{}) (int, error) {
+	token, err := v.authToken(false)
+	if err != nil {
+		return 0, err
+	}
+	status, err := v.request(method, apiPath, token, body, response)
+	if status == http.StatusForbidden && v.Config.Vault.RoleID != "" {
+		if token, err = v.authToken(true); err != nil {
+			return 0, err
+		}
+		status, err = v.request(method, apiPath, token, body, response)
+	}
+	return status, err
+}
+
+// Return the token, logging in with the AppRole when there is none yet or when renew is set.
+func (v *VaultStorage) authToken(renew bool) (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if v.token != "" && !renew {
+		return v.token, nil
+	}
+	if v.Config.Vault.RoleID == "" {
+		return v.token, nil
+	}
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	login := map[string]string{"role_id": v.Config.Vault.RoleID, "secret_id": v.Config.Vault.SecretID}
+	if _, err := v.request(http.MethodPost, "auth/"+v.Config.Vault.AppRoleMount+"/login", "", login, &response); err != nil {
+		return "", err
+	}
+	if response.Auth.ClientToken == "" {
+		return "", errors.New("The Vault AppRole login returned no token.")
+	}
+	v.token = response.Auth.ClientToken
+	return v.token, nil
+}
+
+func (v *VaultStorage) request(method, apiPath, token string, body interface{}, response interface{}) (int, error) {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		if bodyBytes, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(v.Config.Vault.URL, "/")+"/v1/"+apiPath, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if v.Config.Vault.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.Config.Vault.Namespace)
+	}
+	httpResponse, err := v.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer httpResponse.Body.Close()
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return httpResponse.StatusCode, err
+	}
+	if httpResponse.StatusCode < 300 {
+		if response != nil && len(responseBytes) > 0 {
+			return httpResponse.StatusCode, json.Unmarshal(responseBytes, response)
+		}
+		return httpResponse.StatusCode, nil
+	}
+	if response != nil {
+		// A 404 on a deleted secret still has its metadata.
+		_ = json.Unmarshal(responseBytes, response)
+	}
+	var vaultErrors struct {
+		Errors []string `json:"errors"`
+	}
+	_ = json.Unmarshal(responseBytes, &vaultErrors)
+	return httpResponse.StatusCode, errors.New("Vault " + method + " " + apiPath + ": " +
+		httpResponse.Status + " " + strings.Join(vaultErrors.Errors, ", "))
+}
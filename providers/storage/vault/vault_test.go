@@ -0,0 +1,94 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/vault/vaulttest"
+)
+
+func TestVaultStorage(t *testing.T) {
+	server := vaulttest.NewServer("root-token")
+	defer server.Close()
+	vaultStorage, err := NewVaultStorage(storage.StorageConfig{
+		Type:  storage.StorageTypeVault,
+		Path:  "letsencrypt/certificates",
+		Vault: storage.VaultConfig{URL: server.URL(), Token: "root-token"},
+	})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	if _, err := vaultStorage.ReadFile("example.com", "example.com.crt"); !storage.IsNotExist(err) {
+		t.Error("Error: expected a missing file ", err)
+	}
+	if err := vaultStorage.WriteFile("example.com", "example.com.crt", []byte("certificate")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	binary := []byte{0x30, 0x82, 0xff, 0x00}
+	if err := vaultStorage.WriteFile("example.com", "example.com.p12", binary); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := vaultStorage.WriteFile("www.example.com", "www.example.com.crt", []byte("other")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+
+	secret := server.Secret("letsencrypt/certificates/example.com")
+	if secret["crt"] != "certificate" || secret["p12_base64"] == "" {
+		t.Error("Error: unexpected secret fields ", secret)
+	}
+	content, err := vaultStorage.ReadFile("example.com", "example.com.p12")
+	if err != nil || string(content) != string(binary) {
+		t.Error("Error: the binary file wasn't read back ", err)
+	}
+	if _, err := vaultStorage.ReadFile("example.com", "example.com.key"); !storage.IsNotExist(err) {
+		t.Error("Error: expected a missing field ", err)
+	}
+	dirs, err := vaultStorage.ListDirs()
+	if err != nil || len(dirs) != 2 || dirs[0] != "example.com" || dirs[1] != "www.example.com" {
+		t.Error("Error: unexpected directories ", dirs, err)
+	}
+
+	// The other fields of the secret are kept.
+	for _, name := range []string{"example.com.p12", "example.com.p12", "example.com.key"} {
+		if err := vaultStorage.RemoveFile("example.com", name); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	if secret := server.Secret("letsencrypt/certificates/example.com"); len(secret) != 1 || secret["crt"] != "certificate" {
+		t.Error("Error: unexpected secret fields after the removal ", secret)
+	}
+}
+
+func TestVaultStorageAppRole(t *testing.T) {
+	server := vaulttest.NewServer("root-token")
+	defer server.Close()
+	server.RoleID, server.SecretID = "role", "secret"
+	vaultStorage, err := NewVaultStorage(storage.StorageConfig{
+		Path:  "letsencrypt/account",
+		Vault: storage.VaultConfig{URL: server.URL(), RoleID: "role", SecretID: "secret"},
+	})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := vaultStorage.WriteFile("", "registration.json", []byte("{}")); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	// The token expired, the storage logs in again.
+	server.RevokeTokens()
+	content, err := vaultStorage.ReadFile("", "registration.json")
+	if err != nil || string(content) != "{}" {
+		t.Error("Error: the file wasn't read after a new login ", err)
+	}
+	if server.Secret("letsencrypt/account")["registration.json"] != "{}" {
+		t.Error("Error: the account isn't at the storage path")
+	}
+
+	wrongSecret, _ := NewVaultStorage(storage.StorageConfig{
+		Path:  "letsencrypt/account",
+		Vault: storage.VaultConfig{URL: server.URL(), RoleID: "role", SecretID: "wrong"},
+	})
+	if _, err := wrongSecret.ReadFile("", "registration.json"); err == nil {
+		t.Error("Error: read with a wrong secret ID")
+	}
+}
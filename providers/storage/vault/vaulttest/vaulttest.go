@@ -0,0 +1,199 @@
+// Package vaulttest is a stand-in of the Vault API for the tests: token and AppRole auth and a
+// KV version 2 mount, kept in memory.
+package vaulttest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type secret struct {
+	data    map[string]string
+	version int
+}
+
+type Server struct {
+	// The mount of the KV secrets engine, "secret".
+	Mount string
+	// Credentials of the AppRole, logging in gives a new token.
+	RoleID   string
+	SecretID string
+
+	server  *httptest.Server
+	mutex   sync.Mutex
+	tokens  map[string]bool
+	secrets map[string]*secret
+}
+
+// Start the server, rootToken is accepted until RevokeTokens is called.
+func NewServer(rootToken string) *Server {
+	s := &Server{
+		Mount:   "secret",
+		tokens:  map[string]bool{rootToken: true},
+		secrets: make(map[string]*secret),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Make every token expire, as a token reaching the end of its TTL.
+func (s *Server) RevokeTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+// Return the fields of the secret at path in the mount, nil when there is none.
+func (s *Server) Secret(path string) map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stored, ok := s.secrets[path]; ok {
+		return stored.data
+	}
+	return nil
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	apiPath := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(apiPath, "auth/approle/login") && r.Method == http.MethodPost {
+		s.login(w, r)
+		return
+	}
+	if !s.tokens[r.Header.Get("X-Vault-Token")] {
+		writeErrors(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	switch {
+	case strings.HasPrefix(apiPath, s.Mount+"/data/"):
+		path := strings.TrimPrefix(apiPath, s.Mount+"/data/")
+		switch r.Method {
+		case http.MethodGet:
+			s.read(w, path)
+		case http.MethodPost, http.MethodPut:
+			s.write(w, r, path)
+		default:
+			writeErrors(w, http.StatusMethodNotAllowed, "unsupported operation")
+		}
+	case strings.HasPrefix(apiPath, s.Mount+"/metadata/") && r.Method == "LIST":
+		s.list(w, strings.TrimPrefix(apiPath, s.Mount+"/metadata/"))
+	default:
+		writeErrors(w, http.StatusNotFound, "no handler for route "+apiPath)
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var login struct {
+		RoleID   string `json:"role_id"`
+		SecretID string `json:"secret_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.RoleID == "" || login.RoleID != s.RoleID || login.SecretID != s.SecretID {
+		writeErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+	tokenBytes := make([]byte, 16)
+	_, _ = rand.Read(tokenBytes)
+	token := "s." + hex.EncodeToString(tokenBytes)
+	s.tokens[token] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"auth": map[string]interface{}{"client_token": token, "lease_duration": 3600},
+	})
+}
+
+func (s *Server) read(w http.ResponseWriter, path string) {
+	stored, ok := s.secrets[path]
+	if !ok {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"data":     stored.data,
+			"metadata": map[string]int{"version": stored.version},
+		},
+	})
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, path string) {
+	var request struct {
+		Data    map[string]string `json:"data"`
+		Options struct {
+			CAS *int `json:"cas"`
+		} `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stored, ok := s.secrets[path]
+	if !ok {
+		stored = &secret{}
+	}
+	if request.Options.CAS != nil && *request.Options.CAS != stored.version {
+		writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+		return
+	}
+	stored.data = request.Data
+	stored.version++
+	s.secrets[path] = stored
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]int{"version": stored.version},
+	})
+}
+
+func (s *Server) list(w http.ResponseWriter, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	keys := make(map[string]bool)
+	for path := range s.secrets {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(path, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		keys[key] = true
+	}
+	if len(keys) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+	var sorted []string
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string][]string{"keys": sorted}})
+}
+
+func writeErrors(w http.ResponseWriter, status int, messages ...string) {
+	if messages == nil {
+		messages = []string{}
+	}
+	writeJSON(w, status, map[string][]string{"errors": messages})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,77 @@
+package lets_encrypt
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/lock"
+)
+
+// Run RenewCertificates now and then every interval, until the context is done. With LeaderElection,
+// the nodes sharing the Locker elect one of them to run the renewals: the others try to take over
+// every LockTTL, so a leader that stopped is replaced within about two TTLs.
+func (LE *LetsEncrypt) RunScheduler(ctx context.Context, interval time.Duration) error {
+	if LE.LeaderElection && LE.Locker == nil {
+		return errors.New("The leader election needs a Locker.")
+	}
+	logger := loggerOrDiscard(LE.Logger)
+	renewals := time.NewTicker(interval)
+	defer renewals.Stop()
+	var campaigns <-chan time.Time
+	if LE.LeaderElection {
+		ticker := time.NewTicker(LE.lockTTL())
+		defer ticker.Stop()
+		campaigns = ticker.C
+	}
+
+	var leader lock.Lease
+	var stopLease func()
+	var lost <-chan struct{}
+	defer func() {
+		if leader != nil {
+			stopLease()
+			leader.Unlock()
+		}
+	}()
+	campaign := func() bool {
+		lease, ok, err := LE.Locker.TryLock(leaderLockKey, LE.lockTTL())
+		if err != nil {
+			logger.Warn("leader election failed", "error", err)
+			return false
+		}
+		if ok {
+			leader = lease
+			stopLease, lost = LE.keepLease(leaderLockKey, lease)
+			logger.Info("elected leader")
+		}
+		return ok
+	}
+	renew := func() {
+		if err := LE.RenewCertificates(); err != nil {
+			logger.Error("renewals failed", "error", err)
+		}
+	}
+
+	if !LE.LeaderElection || campaign() {
+		renew()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lost:
+			logger.Warn("leadership lost")
+			stopLease()
+			leader, lost = nil, nil
+		case <-campaigns:
+			if leader == nil && campaign() {
+				renew()
+			}
+		case <-renewals.C:
+			if !LE.LeaderElection || leader != nil {
+				renew()
+			}
+		}
+	}
+}
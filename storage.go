@@ -0,0 +1,34 @@
+package lets_encrypt
+
+import (
+	"errors"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/database"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/file"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/s3"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/vault"
+)
+
+// Create the storage of the config, a directory at its path when no type is given.
+func InitStorage(config storage.StorageConfig) (storage.Storage, error) {
+	switch config.Type {
+	case "", storage.StorageTypeFile:
+		return file.InitStorage(config)
+	case storage.StorageTypeVault:
+		return vault.InitStorage(config)
+	case storage.StorageTypeS3:
+		return s3.InitStorage(config)
+	case storage.StorageTypeDatabase:
+		return database.InitStorage(config)
+	}
+	return nil, errors.New("Unknown storage type: " + config.Type)
+}
+
+// Return the storage of the certificates, the CertificatesRootPath directory when none is set.
+func (LE *LetsEncrypt) certificateStorage() storage.Storage {
+	if LE.Storage != nil {
+		return LE.Storage
+	}
+	return file.NewFileStorage(LE.CertificatesRootPath)
+}
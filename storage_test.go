@@ -0,0 +1,202 @@
+package lets_encrypt
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/database"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage/vault/vaulttest"
+)
+
+func TestVaultStorage(t *testing.T) {
+	vaultServer := vaulttest.NewServer("root-token")
+	defer vaultServer.Close()
+	vaultServer.RoleID, vaultServer.SecretID = "role", "secret"
+	vaultConfig := storage.VaultConfig{URL: vaultServer.URL(), RoleID: "role", SecretID: "secret"}
+
+	dnsServer := acmetest.NewDNSServer()
+	acmeServer, err := acmetest.NewServer(dnsServer)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer acmeServer.Close()
+	acmeServerConfig := ACMEServerConfig{CADirURL: acmeServer.DirectoryURL(), HTTPClient: acmeServer.HTTPClient()}
+	userConfig := LetsEncryptUserConfig{
+		Mail:       "test@example.com",
+		ACMEServer: acmeServerConfig,
+		Storage:    storage.StorageConfig{Type: storage.StorageTypeVault, Path: "letsencrypt/account", Vault: vaultConfig},
+	}
+	user, err := InitLetsEncryptUser(userConfig)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	account := vaultServer.Secret("letsencrypt/account")
+	if account["privKey.pem"] == "" || account["registration.json"] == "" {
+		t.Fatal("Error: the account wasn't written in Vault ", account)
+	}
+	reloaded, err := InitLetsEncryptUser(userConfig)
+	if err != nil || reloaded.Registration.URI != user.Registration.URI {
+		t.Error("Error: the account in Vault wasn't reused ", err)
+	}
+
+	LE, err := InitLetsEncryptWithACMEServer("", user.GetLEUser(), acmeServerConfig)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	LE.Validation.Roots = acmeServer.Roots()
+	LE.Storage, err = InitStorage(storage.StorageConfig{Type: storage.StorageTypeVault, Path: "letsencrypt/certificates", Vault: vaultConfig})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{
+		ReuseKey:      Bool(true),
+		OutputFormats: []OutputFormatConfig{{Type: OutputFormatPKCS12, Password: "changeit"}},
+	}}}
+	if err := LE.SetDNSProvider(dns.DNSProvider{DNSServer: dnsServer, PollingInterval: time.Millisecond}, skipPropagationCheck); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	secret := vaultServer.Secret("letsencrypt/certificates/www.example.com")
+	for _, field := range []string{"crt", "key", "json", "p12_base64"} {
+		if secret[field] == "" {
+			t.Error("Error: missing field in the Vault secret ", field)
+		}
+	}
+	if renew, err := LE.NeedsRenewal("www.example.com"); err != nil || renew {
+		t.Error("Error: the certificate in Vault wasn't found ", err)
+	}
+	// Renewal with the key stored in Vault.
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if vaultServer.Secret("letsencrypt/certificates/www.example.com")["key"] != secret["key"] {
+		t.Error("Error: the key stored in Vault wasn't reused")
+	}
+	expiries, scanErrors := certificatesExpiry(LE.Storage)
+	if _, ok := expiries["www.example.com"]; !ok || scanErrors != 0 {
+		t.Error("Error: the certificates in Vault weren't listed ", expiries, scanErrors)
+	}
+}
+
+// Two stateless workers sharing a bucket: what one obtains, the other sees.
+func TestS3StorageSharedByWorkers(t *testing.T) {
+	backend := s3mem.New()
+	s3Server := httptest.NewServer(gofakes3.New(backend).Server())
+	defer s3Server.Close()
+	if err := backend.CreateBucket("letsencrypt"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	s3URL, _ := url.Parse(s3Server.URL)
+	storageConfig := storage.StorageConfig{Type: storage.StorageTypeS3, Path: "certificates", S3: storage.S3Config{
+		Endpoint: s3URL.Host, Region: "us-east-1", Bucket: "letsencrypt", AccessKey: "access", SecretKey: "secret", Insecure: true,
+	}}
+
+	dnsServer := acmetest.NewDNSServer()
+	first, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	second, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	for _, worker := range []*LetsEncrypt{first, second} {
+		var err error
+		if worker.Storage, err = InitStorage(storageConfig); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	if renew, err := second.NeedsRenewal("www.example.com"); err != nil || !renew {
+		t.Fatal("Error: a missing certificate must be asked ", err)
+	}
+	if err := first.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if renew, err := second.NeedsRenewal("www.example.com"); err != nil || renew {
+		t.Error("Error: the certificate obtained by the other worker wasn't found ", err)
+	}
+	if _, err := backend.HeadObject("letsencrypt", "certificates/www.example.com/www.example.com.crt"); err != nil {
+		t.Error("Error: the certificate isn't at the expected key ", err)
+	}
+}
+
+func TestDatabaseStorage(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	var err error
+	LE.Storage, err = InitStorage(storage.StorageConfig{Type: storage.StorageTypeDatabase, Database: storage.DatabaseConfig{
+		Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "certificates.db"),
+	}})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{ReuseKey: Bool(true)}}}
+	for i := 0; i < 2; i++ {
+		if err := LE.AskCertificate("www.example.com"); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	databaseStorage := LE.Storage.(*database.DatabaseStorage)
+	var versions, keys int
+	err = databaseStorage.DB.QueryRow(`SELECT COUNT(*), COUNT(DISTINCT private_key) FROM certificate_versions
+		WHERE domain = 'www.example.com'`).Scan(&versions, &keys)
+	if err != nil || versions != 2 || keys != 1 {
+		t.Error("Error: expected 2 versions sharing their key ", versions, keys, err)
+	}
+	if renew, err := LE.NeedsRenewal("www.example.com"); err != nil || renew {
+		t.Error("Error: the certificate in the database wasn't found ", err)
+	}
+}
+
+// The nodes sharing the account in Vault register it once, and a Vault failure never replaces it.
+func TestAccountInRemoteStorage(t *testing.T) {
+	distributedLockPollingInterval = 10 * time.Millisecond
+	vaultServer := vaulttest.NewServer("root-token")
+	defer vaultServer.Close()
+	acmeServer, err := acmetest.NewServer(acmetest.NewDNSServer())
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer acmeServer.Close()
+	config := LetsEncryptUserConfig{
+		Mail:       "test@example.com",
+		ACMEServer: ACMEServerConfig{CADirURL: acmeServer.DirectoryURL(), HTTPClient: acmeServer.HTTPClient()},
+		Storage: storage.StorageConfig{Type: storage.StorageTypeVault, Path: "letsencrypt/account",
+			Vault: storage.VaultConfig{URL: vaultServer.URL(), Token: "root-token"}},
+		Locker: newTestDatabase(t),
+	}
+	users := make([]*LetsEncryptUser, 3)
+	errs := make([]error, len(users))
+	var wg sync.WaitGroup
+	for i := range users {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			users[i], errs[i] = InitLetsEncryptUser(config)
+		}(i)
+	}
+	wg.Wait()
+	for i := range users {
+		if errs[i] != nil {
+			t.Fatal("Error: ", errs[i])
+		}
+		if users[i].Registration.URI != users[0].Registration.URI {
+			t.Error("Error: the nodes registered several accounts")
+		}
+	}
+
+	account := vaultServer.Secret("letsencrypt/account")
+	vaultServer.RevokeTokens()
+	if _, err := InitLetsEncryptUser(config); err == nil {
+		t.Error("Error: the account was loaded from a failing Vault")
+	}
+	if vaultServer.Secret("letsencrypt/account")["privKey.pem"] != account["privKey.pem"] {
+		t.Error("Error: the account key was replaced")
+	}
+}
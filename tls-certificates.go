@@ -0,0 +1,273 @@
+package lets_encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DumesnyJeremy/lets-encrypt/providers/dns"
+	"github.com/DumesnyJeremy/lets-encrypt/providers/storage"
+)
+
+// Serves the stored certificates through tls.Config.GetCertificate, picked by SNI. The certificates
+// this client obtains are swapped in memory as soon as they are written, Run picks up the ones
+// written by the other processes sharing the storage.
+type TLSCertificates struct {
+	LE *LetsEncrypt
+	// Optional, issues the certificates of the names without one on their first handshake.
+	// Set it before serving.
+	OnDemand *OnDemandConfig
+
+	mutex sync.RWMutex
+	// The loaded certificates by directory, with the PEM chain they were loaded from.
+	loaded map[string]loadedCertificate
+	// The loaded certificates by the names they cover, wildcards included.
+	byName map[string]*tls.Certificate
+	stop   func()
+
+	onDemandMutex sync.Mutex
+	// The on-demand issuances running, by name, and the start times of the recent ones.
+	issuing map[string]*onDemandIssuance
+	started []time.Time
+	// When the last issuance of the names failed, see OnDemandConfig.FailureBackoff.
+	failed map[string]time.Time
+}
+
+type loadedCertificate struct {
+	certificatePEM []byte
+	certificate    *tls.Certificate
+}
+
+// Load the stored certificates and keep them up to date, Close stops following the client. A client
+// built without InitLetsEncrypt nor SetDNSProvider obtains nothing, its certificates come from Run.
+func NewTLSCertificates(LE *LetsEncrypt) (*TLSCertificates, error) {
+	c := &TLSCertificates{LE: LE, loaded: make(map[string]loadedCertificate), byName: make(map[string]*tls.Certificate)}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	c.stop = LE.watchers.add(func(fullDomainName string, certificatePEM []byte, privateKeyPEM []byte) {
+		if err := c.load(fullDomainName, certificatePEM, privateKeyPEM); err != nil {
+			loggerOrDiscard(LE.Logger).Error("failed to load the certificate", "domain", fullDomainName, "error", err)
+		}
+	})
+	return c, nil
+}
+
+// Stop following the certificates obtained by the client, those loaded are still served.
+func (c *TLSCertificates) Close() {
+	c.stop()
+}
+
+// A server config serving the certificates, to complete with the settings of the server.
+func (c *TLSCertificates) TLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: c.GetCertificate}
+}
+
+// Return the certificate covering the server name of the client: the one of the name itself,
+// or else the one of the wildcard of its parent domain.
+func (c *TLSCertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	serverName := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if serverName == "" {
+		return nil, errors.New("The client sent no server name.")
+	}
+	// A wildcard or an address isn't a host name, it must not be issued on demand.
+	if strings.Contains(serverName, "*") || net.ParseIP(serverName) != nil {
+		return nil, errors.New("Invalid server name: " + serverName)
+	}
+	serverName, err := dns.ToASCII(serverName)
+	if err != nil {
+		return nil, err
+	}
+	if certificate := c.lookup(serverName); certificate != nil {
+		return certificate, nil
+	}
+	if c.OnDemand != nil {
+		return c.obtainOnDemand(hello, serverName)
+	}
+	return nil, errors.New("No certificate for " + serverName + ".")
+}
+
+// Return the loaded certificate of the name or of the wildcard of its parent, nil without any.
+func (c *TLSCertificates) lookup(serverName string) *tls.Certificate {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if certificate, ok := c.byName[serverName]; ok {
+		return certificate
+	}
+	if _, parent, ok := strings.Cut(serverName, "."); ok {
+		if certificate, ok := c.byName["*."+parent]; ok {
+			return certificate
+		}
+	}
+	return nil
+}
+
+// Reload the certificates of the storage that changed, and drop those removed from it. A certificate
+// failing to load is logged and skipped, the one loaded before is still served.
+func (c *TLSCertificates) Reload() error {
+	dirs, err := c.LE.certificateStorage().ListDirs()
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool)
+	for _, dir := range dirs {
+		stored[dir] = true
+		if err := c.reloadDomain(dir); err != nil {
+			loggerOrDiscard(c.LE.Logger).Error("failed to load the certificate", "domain", dir, "error", err)
+		}
+	}
+	c.mutex.Lock()
+	for dir := range c.loaded {
+		if !stored[dir] {
+			delete(c.loaded, dir)
+		}
+	}
+	c.index()
+	c.mutex.Unlock()
+	return nil
+}
+
+// Run Reload every interval until the context is done, for the certificates renewed by other processes.
+func (c *TLSCertificates) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := c.Reload(); err != nil {
+				loggerOrDiscard(c.LE.Logger).Error("failed to reload the certificates", "error", err)
+			}
+		}
+	}
+}
+
+// Load the stored certificate of the domain when its chain changed since it was loaded.
+func (c *TLSCertificates) reloadDomain(fullDomainName string) error {
+	certificatePEM, privateKeyPEM, err := c.LE.ReadCertificate(fullDomainName)
+	if storage.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.load(fullDomainName, certificatePEM, privateKeyPEM)
+}
+
+// Load the certificate of the domain when its chain differs from the loaded one.
+func (c *TLSCertificates) load(fullDomainName string, certificatePEM []byte, privateKeyPEM []byte) error {
+	c.mutex.RLock()
+	loaded, ok := c.loaded[fullDomainName]
+	c.mutex.RUnlock()
+	if ok && bytes.Equal(loaded.certificatePEM, certificatePEM) {
+		return nil
+	}
+	certificate, err := c.LE.tlsCertificate(fullDomainName, certificatePEM, privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loaded[fullDomainName] = loadedCertificate{certificatePEM: certificatePEM, certificate: certificate}
+	c.index()
+	return nil
+}
+
+// Rebuild byName, the certificate expiring last wins a name covered by several.
+func (c *TLSCertificates) index() {
+	c.byName = make(map[string]*tls.Certificate)
+	for _, loaded := range c.loaded {
+		for _, name := range loaded.certificate.Leaf.DNSNames {
+			name = strings.ToLower(name)
+			if other, ok := c.byName[name]; !ok || loaded.certificate.Leaf.NotAfter.After(other.Leaf.NotAfter) {
+				c.byName[name] = loaded.certificate
+			}
+		}
+	}
+}
+
+// Build the TLS certificate of the chain, signed by the private key or else by the key of the key store.
+func (LE *LetsEncrypt) tlsCertificate(fullDomainName string, certificatePEM []byte, privateKeyPEM []byte) (*tls.Certificate, error) {
+	if privateKeyPEM != nil {
+		certificate, err := tls.X509KeyPair(certificatePEM, privateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		return &certificate, nil
+	}
+	if LE.KeyStore == nil {
+		return nil, errors.New("The key of " + fullDomainName + " is in a key store, set KeyStore.")
+	}
+	var certificate tls.Certificate
+	for block, rest := pem.Decode(certificatePEM); block != nil; block, rest = pem.Decode(rest) {
+		certificate.Certificate = append(certificate.Certificate, block.Bytes)
+	}
+	if len(certificate.Certificate) == 0 {
+		return nil, errors.New("No certificate found for " + fullDomainName + ".")
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	signer, err := LE.KeyStore.FindSigner(fullDomainName)
+	if err != nil {
+		return nil, err
+	}
+	if err := matchPublicKey(leaf, signer.Public()); err != nil {
+		return nil, err
+	}
+	certificate.PrivateKey, certificate.Leaf = signer, leaf
+	return &certificate, nil
+}
+
+// The functions called with each certificate the client writes, its key nil for a key store.
+type certificateWatcher func(fullDomainName string, certificatePEM []byte, privateKeyPEM []byte)
+
+type certificateWatchers struct {
+	mutex    sync.Mutex
+	next     int
+	watchers map[int]certificateWatcher
+}
+
+// Add the watcher, the returned function removes it.
+func (w *certificateWatchers) add(watcher certificateWatcher) func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.watchers == nil {
+		w.watchers = make(map[int]certificateWatcher)
+	}
+	id := w.next
+	w.next++
+	w.watchers[id] = watcher
+	return func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		delete(w.watchers, id)
+	}
+}
+
+func (w *certificateWatchers) written(fullDomainName string, certificatePEM []byte, privateKeyPEM []byte) {
+	if w == nil {
+		return
+	}
+	w.mutex.Lock()
+	watchers := make([]certificateWatcher, 0, len(w.watchers))
+	for _, watcher := range w.watchers {
+		watchers = append(watchers, watcher)
+	}
+	w.mutex.Unlock()
+	for _, watcher := range watchers {
+		watcher(fullDomainName, certificatePEM, privateKeyPEM)
+	}
+}
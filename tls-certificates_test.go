@@ -0,0 +1,135 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func serverName(t *testing.T, certificates *TLSCertificates, name string) *tls.Certificate {
+	certificate, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	return certificate
+}
+
+func TestTLSCertificates(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	for _, domain := range []string{"www.example.com", "*.example.net"} {
+		if err := LE.AskCertificate(domain); err != nil {
+			t.Fatal("Error: ", err)
+		}
+	}
+	certificates, err := NewTLSCertificates(LE)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer certificates.Close()
+
+	www := serverName(t, certificates, "www.example.com")
+	if serverName(t, certificates, "WWW.Example.COM.") != www {
+		t.Error("Error: the server name wasn't normalized")
+	}
+	if serverName(t, certificates, "api.example.net").Leaf.DNSNames[0] != "*.example.net" {
+		t.Error("Error: the wildcard wasn't picked")
+	}
+	for _, name := range []string{"", "other.example.com", "a.b.example.net", "example.net"} {
+		if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Error("Error: a certificate was served for ", name)
+		}
+	}
+
+	// A renewal of this client is served at once.
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	renewed := serverName(t, certificates, "www.example.com")
+	if renewed.Leaf.Equal(www.Leaf) {
+		t.Error("Error: the renewed certificate wasn't swapped in")
+	}
+
+	// The files written are served even when a hook fails next.
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{Hooks: []string{"exit 1"}}}}
+	if err := LE.AskCertificate("www.example.com"); err == nil {
+		t.Fatal("Error: the hook didn't fail")
+	}
+	if serverName(t, certificates, "www.example.com").Leaf.Equal(renewed.Leaf) {
+		t.Error("Error: the certificate written before the failed hook wasn't swapped in")
+	}
+	LE.Certificates = nil
+	renewed = serverName(t, certificates, "www.example.com")
+
+	// A renewal of another process is served once reloaded.
+	other := *LE
+	other.watchers = nil
+	if err := other.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if !serverName(t, certificates, "www.example.com").Leaf.Equal(renewed.Leaf) {
+		t.Error("Error: the certificate changed before the reload")
+	}
+	if err := certificates.Reload(); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	reloaded := serverName(t, certificates, "www.example.com")
+	if reloaded.Leaf.Equal(renewed.Leaf) {
+		t.Error("Error: the certificate wasn't reloaded")
+	}
+
+	// A handshake verifies to the roots of the ACME server.
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		_ = tls.Server(serverConn, certificates.TLSConfig()).Handshake()
+	}()
+	client := tls.Client(clientConn, &tls.Config{ServerName: "www.example.com", RootCAs: LE.Validation.Roots})
+	if err := client.Handshake(); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	if !client.ConnectionState().PeerCertificates[0].Equal(reloaded.Leaf) {
+		t.Error("Error: another certificate was served")
+	}
+}
+
+func TestTLSCertificatesWithKeyStore(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	LE.Certificates = []CertificateConfig{{Domain: "www.example.com", CertificateProfile: CertificateProfile{UseKeyStore: Bool(true)}}}
+	LE.KeyStore = &memoryKeyStore{keys: make(map[string]crypto.Signer)}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	certificates, err := NewTLSCertificates(LE)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer certificates.Close()
+	if _, ok := serverName(t, certificates, "www.example.com").PrivateKey.(crypto.Signer); !ok {
+		t.Error("Error: the key of the key store wasn't used")
+	}
+
+	// The other certificates are still served.
+	emptyStore := &memoryKeyStore{keys: make(map[string]crypto.Signer)}
+	LE.KeyStore = emptyStore
+	if err := LE.AskCertificate("api.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	certificates, err = NewTLSCertificates(LE)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	defer certificates.Close()
+	if _, err := certificates.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"}); err == nil {
+		t.Error("Error: a certificate was loaded without its key")
+	}
+	serverName(t, certificates, "api.example.com")
+	if len(emptyStore.keys) != 0 {
+		t.Error("Error: a key was generated in the key store")
+	}
+}
@@ -0,0 +1,169 @@
+package lets_encrypt
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// Longest validity accepted when the config sets none, the limit of the CA/Browser Forum.
+const DefaultMaxValidity = 398 * 24 * time.Hour
+
+// Shortest remaining validity accepted when the config sets none.
+const DefaultMinValidity = 24 * time.Hour
+
+// How far in the future the validity may start, for the clocks of the CA and of this host.
+const clockSkew = 5 * time.Minute
+
+// Checks of the certificates returned by the CA, before they replace the stored ones.
+type CertificateValidationConfig struct {
+	// PEM file of the roots the chains must verify to, the system roots when neither RootsFile nor
+	// Roots is set. The staging directory of Let's Encrypt and the private CAs need their roots here.
+	RootsFile string         `mapstructure:"roots_file"`
+	Roots     *x509.CertPool `mapstructure:"-"`
+	// Don't verify the chains, for the CAs whose roots aren't at hand.
+	SkipChain bool `mapstructure:"skip_chain"`
+	// Longest validity accepted, DefaultMaxValidity when zero.
+	MaxValidity time.Duration `mapstructure:"max_validity"`
+	// Shortest remaining validity accepted, DefaultMinValidity when zero.
+	MinValidity time.Duration `mapstructure:"min_validity"`
+}
+
+// Returned when an issued certificate fails a check, nothing is written then.
+type ValidationError struct {
+	Domain   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "The certificate issued for " + e.Domain + " is invalid: " + strings.Join(e.Problems, "; ") + "."
+}
+
+// Check that the leaf matches the private key, covers the domains, verifies to a trusted root unless
+// SkipChain is set and has a sane validity period. Every failed check is reported in the
+// ValidationError. publicKey is the key of a key store, the private key of the certificates is
+// checked when it is nil.
+func (config CertificateValidationConfig) validate(certificates *certificate.Resource, domains []string, publicKey crypto.PublicKey, now time.Time) error {
+	validationError := &ValidationError{Domain: domains[0]}
+	chain, err := certcrypto.ParsePEMBundle(certificates.Certificate)
+	if err != nil {
+		validationError.Problems = append(validationError.Problems, "unreadable certificate: "+err.Error())
+		return validationError
+	}
+	leaf := chain[0]
+
+	if publicKey == nil {
+		err = matchPrivateKey(leaf, certificates.PrivateKey)
+	} else {
+		err = matchPublicKey(leaf, publicKey)
+	}
+	if err != nil {
+		validationError.Problems = append(validationError.Problems, err.Error())
+	}
+	for _, domain := range domains {
+		if !containsName(leaf.DNSNames, domain) {
+			validationError.Problems = append(validationError.Problems, "missing name "+domain)
+		}
+	}
+	if !config.SkipChain {
+		roots, err := config.roots()
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			validationError.Problems = append(validationError.Problems, "untrusted chain: "+err.Error())
+		}
+	}
+
+	maxValidity, minValidity := config.MaxValidity, config.MinValidity
+	if maxValidity == 0 {
+		maxValidity = DefaultMaxValidity
+	}
+	if minValidity == 0 {
+		minValidity = DefaultMinValidity
+	}
+	if leaf.NotBefore.After(now.Add(clockSkew)) {
+		validationError.Problems = append(validationError.Problems, "not valid before "+leaf.NotBefore.Format(time.RFC3339))
+	}
+	if leaf.NotAfter.Sub(now) < minValidity {
+		validationError.Problems = append(validationError.Problems, "expires on "+leaf.NotAfter.Format(time.RFC3339))
+	}
+	if leaf.NotAfter.Sub(leaf.NotBefore) > maxValidity {
+		validationError.Problems = append(validationError.Problems, "valid for "+leaf.NotAfter.Sub(leaf.NotBefore).String()+
+			", more than "+maxValidity.String())
+	}
+
+	if len(validationError.Problems) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+// The roots of the config, nil for the system roots.
+func (config CertificateValidationConfig) roots() (*x509.CertPool, error) {
+	if config.RootsFile == "" {
+		return config.Roots, nil
+	}
+	roots := x509.NewCertPool()
+	if config.Roots != nil {
+		roots = config.Roots.Clone()
+	}
+	rootsPEM, err := ioutil.ReadFile(config.RootsFile)
+	if err != nil {
+		return nil, err
+	}
+	if !roots.AppendCertsFromPEM(rootsPEM) {
+		return nil, errors.New("No certificate found in " + config.RootsFile + ".")
+	}
+	return roots, nil
+}
+
+func matchPrivateKey(leaf *x509.Certificate, privateKeyPEM []byte) error {
+	// ParsePEMPrivateKey doesn't check there is a PEM block.
+	if block, _ := pem.Decode(privateKeyPEM); block == nil {
+		return errors.New("unreadable private key")
+	}
+	privateKey, err := certcrypto.ParsePEMPrivateKey(privateKeyPEM)
+	if err != nil {
+		return errors.New("unreadable private key: " + err.Error())
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("unsupported private key")
+	}
+	return matchPublicKey(leaf, signer.Public())
+}
+
+func matchPublicKey(leaf *x509.Certificate, publicKey crypto.PublicKey) error {
+	leafKey, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !leafKey.Equal(publicKey) {
+		return errors.New("the certificate doesn't match the private key")
+	}
+	return nil
+}
+
+func containsName(names []string, domain string) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package lets_encrypt
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/DumesnyJeremy/lets-encrypt/acmetest"
+)
+
+func TestCertificateValidation(t *testing.T) {
+	now := time.Now()
+	resource := newTestResource(t, "www.example.com", now.Add(60*24*time.Hour))
+	leaf, err := certcrypto.ParsePEMCertificate(resource.Certificate)
+	if err != nil {
+		t.Fatal("Error: ", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	config := CertificateValidationConfig{Roots: roots}
+	if err := config.validate(resource, []string{"www.example.com"}, nil, now); err != nil {
+		t.Error("Error: ", err)
+	}
+
+	other := newTestResource(t, "www.example.com", now.Add(60*24*time.Hour))
+	mismatched := *resource
+	mismatched.PrivateKey = other.PrivateKey
+	tests := []struct {
+		name     string
+		config   CertificateValidationConfig
+		resource *certificate.Resource
+		domains  []string
+		now      time.Time
+		problem  string
+	}{
+		{"wrong key", config, &mismatched, []string{"www.example.com"}, now, "doesn't match the private key"},
+		{"missing name", config, resource, []string{"www.example.com", "api.example.com"}, now, "missing name api.example.com"},
+		{"untrusted", CertificateValidationConfig{Roots: x509.NewCertPool()}, resource, []string{"www.example.com"}, now, "untrusted chain"},
+		{"system roots", CertificateValidationConfig{}, resource, []string{"www.example.com"}, now, "untrusted chain"},
+		{"not yet valid", config, resource, []string{"www.example.com"}, leaf.NotBefore.Add(-time.Hour), "not valid before"},
+		{"expiring", config, resource, []string{"www.example.com"}, leaf.NotAfter.Add(-time.Hour), "expires on"},
+		{"too long", CertificateValidationConfig{Roots: roots, MaxValidity: 30 * 24 * time.Hour}, resource, []string{"www.example.com"}, now, "more than 720h0m0s"},
+	}
+	for _, test := range tests {
+		err := test.config.validate(test.resource, test.domains, nil, test.now)
+		var validationError *ValidationError
+		if !errors.As(err, &validationError) || !strings.Contains(err.Error(), test.problem) {
+			t.Error("Error: ", test.name, ": expected ", test.problem, ", got ", err)
+		}
+	}
+
+	// The validity checks are all reported.
+	err = CertificateValidationConfig{Roots: roots, MaxValidity: 30 * 24 * time.Hour}.validate(resource, []string{"www.example.com"}, nil, leaf.NotAfter.Add(-time.Hour))
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) || len(validationError.Problems) != 2 {
+		t.Error("Error: expected the expiry and the validity period, got ", err)
+	}
+}
+
+// An issued certificate failing the checks leaves the stored one in place.
+func TestAskCertificateKeepsPreviousOnInvalidCertificate(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+	certificatePath := filepath.Join(LE.CertificatesRootPath, "www.example.com", "www.example.com.crt")
+	previous, _ := ioutil.ReadFile(certificatePath)
+
+	LE.Validation.Roots = x509.NewCertPool()
+	err := LE.AskCertificate("www.example.com")
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) || validationError.Domain != "www.example.com" {
+		t.Fatal("Error: expected a validation error ", err)
+	}
+	if current, _ := ioutil.ReadFile(certificatePath); string(current) != string(previous) {
+		t.Error("Error: the previous certificate was replaced")
+	}
+}
+
+func TestAskCertificateWithoutRoots(t *testing.T) {
+	dnsServer := acmetest.NewDNSServer()
+	LE, _ := newTestLetsEncrypt(t, dnsServer, dnsServer)
+	// The chain of a CA the system doesn't trust fails without roots, unless it isn't verified.
+	LE.Validation = CertificateValidationConfig{}
+	var validationError *ValidationError
+	if err := LE.AskCertificate("www.example.com"); !errors.As(err, &validationError) {
+		t.Fatal("Error: the chain was trusted without roots ", err)
+	}
+	LE.Validation = CertificateValidationConfig{SkipChain: true}
+	if err := LE.AskCertificate("www.example.com"); err != nil {
+		t.Fatal("Error: ", err)
+	}
+}